@@ -0,0 +1,104 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/rs/zerolog"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/config"
+	"github.com/anas-aso/ssllabs_exporter/internal/validation"
+)
+
+// reason of probes rejected due to missing or wrong credentials
+const reasonUnauthorized = "unauthorized"
+
+var probesRejected = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ssllabs_exporter_probes_rejected_total",
+		Help: "Number of probes rejected before triggering an assessment by reason",
+	},
+	[]string{"reason"},
+)
+
+// check the request credentials if authentication is configured
+func authorized(r *http.Request, cfg *config.AuthConfig) bool {
+	switch {
+	case cfg.BearerToken != "":
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return found && secretEqual(token, cfg.BearerToken)
+	case cfg.BasicAuth != nil:
+		username, password, found := r.BasicAuth()
+		// always compare both to not leak which one is wrong
+		usernameOK := secretEqual(username, cfg.BasicAuth.Username)
+		passwordOK := secretEqual(password, cfg.BasicAuth.Password)
+		return found && usernameOK && passwordOK
+	}
+
+	return true
+}
+
+// restrict the handler to the clients with the credentials, if any is configured
+func authenticated(logger log.Logger, cfg *config.AuthConfig, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, cfg) {
+			logger.Error().Str("remote_address", r.RemoteAddr).Str("path", r.URL.Path).Msg("Unauthorized request")
+			w.Header().Set("WWW-Authenticate", authenticateHeader(cfg))
+			jsonError(w, http.StatusUnauthorized, &validation.Error{Reason: reasonUnauthorized, Message: "missing or invalid credentials"})
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// challenge returned to unauthorized clients
+func authenticateHeader(cfg *config.AuthConfig) string {
+	if cfg.BasicAuth != nil {
+		return `Basic realm="ssllabs_exporter"`
+	}
+
+	return `Bearer realm="ssllabs_exporter"`
+}
+
+// reason of a probe rejected due to an invalid target
+func rejectionReason(err error) string {
+	var e *validation.Error
+	if errors.As(err, &e) {
+		return e.Reason
+	}
+
+	return validation.ReasonInvalid
+}
+
+// compare secrets in constant time
+func secretEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// check whether the normalized target can be assessed
+func allowed(target string, cfg *config.ProbeConfig) error {
+	if !cfg.Allowed(target) {
+		return &validation.Error{Target: target, Reason: validation.ReasonDenied, Message: "target is not allowed"}
+	}
+
+	return nil
+}
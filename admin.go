@@ -0,0 +1,152 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	log "github.com/rs/zerolog"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/config"
+	"github.com/anas-aso/ssllabs_exporter/internal/exporter"
+	"github.com/anas-aso/ssllabs_exporter/internal/validation"
+)
+
+// reasons of rejected admin requests
+const (
+	// no admin credentials are configured
+	reasonAdminDisabled = "admin_disabled"
+	// the request was sent by a page of another site
+	reasonCrossOrigin = "cross_origin"
+)
+
+// cacheEntryResponse is the admin API representation of a cache entry
+type cacheEntryResponse struct {
+	Target    string    `json:"target"`
+	Success   bool      `json:"success"`
+	Grade     string    `json:"grade,omitempty"`
+	ProbeTime time.Time `json:"probe_time"`
+	Expiry    time.Time `json:"expiry"`
+}
+
+// refreshResponse is the admin API representation of a triggered assessment
+type refreshResponse struct {
+	Target string    `json:"target"`
+	Start  time.Time `json:"start"`
+}
+
+// restrict the handler to authenticated administrators
+func adminOnly(logger log.Logger, cfg *config.AdminConfig, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.Enabled() {
+			jsonError(w, http.StatusForbidden, &validation.Error{Reason: reasonAdminDisabled, Message: "admin endpoints require admin credentials in the configuration file"})
+			return
+		}
+
+		if !sameOrigin(r) {
+			logger.Error().Str("remote_address", r.RemoteAddr).Str("path", r.URL.Path).Msg("Cross-origin admin request")
+			jsonError(w, http.StatusForbidden, &validation.Error{Reason: reasonCrossOrigin, Message: "cross-origin requests are not allowed"})
+			return
+		}
+
+		if !authorized(r, &cfg.AuthConfig) {
+			logger.Error().Str("remote_address", r.RemoteAddr).Str("path", r.URL.Path).Msg("Unauthorized admin request")
+			w.Header().Set("WWW-Authenticate", authenticateHeader(&cfg.AuthConfig))
+			jsonError(w, http.StatusUnauthorized, &validation.Error{Reason: reasonUnauthorized, Message: "missing or invalid credentials"})
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// list the cache entries with their expiry time
+func cacheListHandler(w http.ResponseWriter, r *http.Request, resultsCache cache) {
+	cached := resultsCache.list()
+
+	entries := make([]cacheEntryResponse, 0, len(cached))
+	for _, c := range cached {
+		report := exporter.NewReport(c.result)
+		entries = append(entries, cacheEntryResponse{
+			Target:    report.Target,
+			Success:   report.Success,
+			Grade:     report.Grade,
+			ProbeTime: report.ProbeTime,
+			Expiry:    c.expiryTime,
+		})
+	}
+
+	jsonResponse(w, http.StatusOK, entries)
+}
+
+// remove a target from the cache
+func cacheEvictHandler(w http.ResponseWriter, r *http.Request, logger log.Logger, resultsCache cache) {
+	target, err := validation.Target(r.PathValue("target"))
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !resultsCache.remove(target) {
+		jsonError(w, http.StatusNotFound, &validation.Error{Target: target, Reason: reasonNotFound, Message: "no cached assessment for the target"})
+		return
+	}
+
+	logger.Info().Str("target", target).Msg("target evicted from the cache")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// trigger a new SSLLabs assessment of the target. The cached results,
+// if any, are served until the new assessment finishes.
+func cacheRefreshHandler(w http.ResponseWriter, r *http.Request, logger log.Logger, running *assessments, probeConfig *config.ProbeConfig) {
+	target, err := validation.Target(r.PathValue("target"))
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := allowed(target, probeConfig); err != nil {
+		logger.Error().Err(err).Msg("Target not allowed")
+		jsonError(w, http.StatusForbidden, err)
+		return
+	}
+
+	logger.Info().Str("target", target).Msg("re-assessment requested")
+	as := running.refresh(r.Context(), target)
+
+	jsonResponse(w, http.StatusAccepted, refreshResponse{Target: target, Start: as.start})
+}
+
+// check that the requests changing the state were not sent by another site,
+// which could otherwise forge them with the credentials cached by the browser.
+// Browsers set the Origin or Referer headers, other clients are trusted.
+func sameOrigin(r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return true
+	}
+
+	u, err := url.Parse(source)
+	return err == nil && u.Host == r.Host
+}
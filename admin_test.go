@@ -0,0 +1,230 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/rs/zerolog"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/config"
+	"github.com/anas-aso/ssllabs_exporter/internal/exporter"
+)
+
+func TestAdminAPI(t *testing.T) {
+	resultsCache := newMemoryCache(time.Minute, newRetentionPolicy(time.Minute), 0, 0, 0)
+	resultsCache.add("prometheus.io", exporter.Interrupted("prometheus.io", time.Now(), context.DeadlineExceeded))
+
+	running := newAssessments(context.Background(), log.Nop(), time.Minute, resultsCache, false)
+	refreshed := make(chan string, 1)
+	running.handle = func(ctx context.Context, logger log.Logger, target string) *exporter.Result {
+		refreshed <- target
+		return &exporter.Result{Target: target, Registry: prometheus.NewRegistry()}
+	}
+
+	enabled := &config.AdminConfig{AuthConfig: config.AuthConfig{BearerToken: "secret"}}
+	disabled := &config.AdminConfig{}
+	probeConfig := &config.ProbeConfig{Deny: config.TargetMatcher{Suffixes: []string{"internal.example.com"}}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/cache", adminOnly(log.Nop(), enabled, func(w http.ResponseWriter, r *http.Request) {
+		cacheListHandler(w, r, resultsCache)
+	}))
+	mux.HandleFunc("GET /disabled/api/v1/cache", adminOnly(log.Nop(), disabled, func(w http.ResponseWriter, r *http.Request) {
+		cacheListHandler(w, r, resultsCache)
+	}))
+	mux.HandleFunc("DELETE /api/v1/cache/{target}", adminOnly(log.Nop(), enabled, func(w http.ResponseWriter, r *http.Request) {
+		cacheEvictHandler(w, r, log.Nop(), resultsCache)
+	}))
+	mux.HandleFunc("POST /api/v1/cache/{target}/refresh", adminOnly(log.Nop(), enabled, func(w http.ResponseWriter, r *http.Request) {
+		cacheRefreshHandler(w, r, log.Nop(), running, probeConfig)
+	}))
+
+	var cases = []struct {
+		name           string
+		method         string
+		path           string
+		token          string
+		expectedStatus int
+	}{
+		{
+			name:           "admin_disabled",
+			method:         "GET",
+			path:           "/disabled/api/v1/cache",
+			token:          "secret",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "missing_credentials",
+			method:         "GET",
+			path:           "/api/v1/cache",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "list_entries",
+			method:         "GET",
+			path:           "/api/v1/cache",
+			token:          "secret",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "refresh_target",
+			method:         "POST",
+			path:           "/api/v1/cache/grafana.com/refresh",
+			token:          "secret",
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			name:           "refresh_denied_target",
+			method:         "POST",
+			path:           "/api/v1/cache/db.internal.example.com/refresh",
+			token:          "secret",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "evict_target",
+			method:         "DELETE",
+			path:           "/api/v1/cache/prometheus.io",
+			token:          "secret",
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "evict_missing_target",
+			method:         "DELETE",
+			path:           "/api/v1/cache/prometheus.io",
+			token:          "secret",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, nil)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+
+		testRecorder := httptest.NewRecorder()
+		mux.ServeHTTP(testRecorder, req)
+
+		if status := testRecorder.Code; status != c.expectedStatus {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedStatus, status)
+		}
+	}
+
+	select {
+	case target := <-refreshed:
+		if target != "grafana.com" {
+			t.Errorf("Unexpected refreshed target.\nExpected : %v\nGot : %v\n", "grafana.com", target)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Refresh didn't trigger a new assessment")
+	}
+}
+
+func TestAdminOnly(t *testing.T) {
+	enabled := &config.AdminConfig{AuthConfig: config.AuthConfig{BearerToken: "secret"}}
+
+	var cases = []struct {
+		name           string
+		cfg            *config.AdminConfig
+		method         string
+		token          string
+		headers        map[string]string
+		expectedStatus int
+	}{
+		{
+			name:           "admin_disabled",
+			cfg:            &config.AdminConfig{},
+			method:         "POST",
+			token:          "secret",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "missing_credentials",
+			cfg:            enabled,
+			method:         "POST",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "api_client",
+			cfg:            enabled,
+			method:         "POST",
+			token:          "secret",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "same_origin_form",
+			cfg:            enabled,
+			method:         "POST",
+			token:          "secret",
+			headers:        map[string]string{"Origin": "http://exporter:9219"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "cross_origin_form",
+			cfg:            enabled,
+			method:         "POST",
+			token:          "secret",
+			headers:        map[string]string{"Origin": "https://evil.example.com"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "opaque_origin",
+			cfg:            enabled,
+			method:         "POST",
+			token:          "secret",
+			headers:        map[string]string{"Origin": "null"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "cross_site_referer",
+			cfg:            enabled,
+			method:         "POST",
+			token:          "secret",
+			headers:        map[string]string{"Referer": "https://evil.example.com/page"},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "cross_origin_read",
+			cfg:            enabled,
+			method:         "GET",
+			token:          "secret",
+			headers:        map[string]string{"Origin": "https://evil.example.com"},
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, "http://exporter:9219/ui/evict", nil)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		for name, value := range c.headers {
+			req.Header.Set(name, value)
+		}
+
+		testRecorder := httptest.NewRecorder()
+		adminOnly(log.Nop(), c.cfg, func(w http.ResponseWriter, r *http.Request) {})(testRecorder, req)
+
+		if status := testRecorder.Code; status != c.expectedStatus {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedStatus, status)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	log "github.com/rs/zerolog"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/exporter"
+	"github.com/anas-aso/ssllabs_exporter/internal/validation"
+)
+
+// reason of API requests for targets missing from the cache
+const reasonNotFound = "not_found"
+
+// resultResponse is the JSON API representation of a cached assessment
+type resultResponse struct {
+	*exporter.Report
+	CacheExpiry time.Time `json:"cache_expiry"`
+}
+
+func newResultResponse(cached cachedResult) resultResponse {
+	return resultResponse{
+		Report:      exporter.NewReport(cached.result),
+		CacheExpiry: cached.expiryTime,
+	}
+}
+
+// serve the cached assessment of a single target.
+// This never triggers a new assessment.
+func resultHandler(w http.ResponseWriter, r *http.Request, logger log.Logger, resultsCache cache) {
+	target, err := validation.Target(r.PathValue("target"))
+	if err != nil {
+		logger.Error().Err(err).Msg("Invalid target")
+		jsonError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	cached, found := resultsCache.lookup(target)
+	if !found {
+		jsonError(w, http.StatusNotFound, &validation.Error{Target: target, Reason: reasonNotFound, Message: "no cached assessment for the target"})
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, newResultResponse(cached))
+}
+
+// serve all the cached assessments
+func resultsHandler(w http.ResponseWriter, r *http.Request, resultsCache cache) {
+	cached := resultsCache.list()
+
+	results := make([]resultResponse, 0, len(cached))
+	for _, c := range cached {
+		results = append(results, newResultResponse(c))
+	}
+
+	jsonResponse(w, http.StatusOK, results)
+}
+
+// serve the targets assessed in the background by the scheduler
+func targetsHandler(w http.ResponseWriter, r *http.Request, sched *scheduler) {
+	jsonResponse(w, http.StatusOK, sched.list())
+}
+
+// reply with the JSON encoded value
+func jsonResponse(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
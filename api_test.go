@@ -0,0 +1,155 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	ssllabsApi "github.com/essentialkaos/sslscan/v13"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/rs/zerolog"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/config"
+	"github.com/anas-aso/ssllabs_exporter/internal/exporter"
+)
+
+func TestResultsAPI(t *testing.T) {
+	resultsCache := newMemoryCache(time.Minute, newRetentionPolicy(time.Minute), 0, 0, 0)
+	resultsCache.add("prometheus.io", &exporter.Result{
+		Target: "prometheus.io",
+		Start:  time.Now(),
+		Info: &ssllabsApi.AnalyzeInfo{
+			Host:      "prometheus.io",
+			Status:    ssllabsApi.STATUS_READY,
+			TestTime:  time.Now().UnixMilli(),
+			Endpoints: []*ssllabsApi.EndpointInfo{{IPAddress: "192.0.2.1", Grade: "A+"}},
+			Certs:     []*ssllabsApi.Cert{{Subject: "CN=prometheus.io", SerialNumber: "01"}},
+		},
+		Registry: prometheus.NewRegistry(),
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/results", func(w http.ResponseWriter, r *http.Request) {
+		resultsHandler(w, r, resultsCache)
+	})
+	mux.HandleFunc("GET /api/v1/results/{target}", func(w http.ResponseWriter, r *http.Request) {
+		resultHandler(w, r, log.Nop(), resultsCache)
+	})
+
+	var cases = []struct {
+		name           string
+		path           string
+		expectedStatus int
+	}{
+		{
+			name:           "all_results",
+			path:           "/api/v1/results",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "cached_target",
+			path:           "/api/v1/results/Prometheus.IO",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "missing_target",
+			path:           "/api/v1/results/grafana.com",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "invalid_target",
+			path:           "/api/v1/results/127.0.0.1",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, c := range cases {
+		testRecorder := httptest.NewRecorder()
+		mux.ServeHTTP(testRecorder, httptest.NewRequest("GET", c.path, nil))
+
+		if status := testRecorder.Code; status != c.expectedStatus {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedStatus, status)
+		}
+	}
+
+	// check the content of a cached result
+	testRecorder := httptest.NewRecorder()
+	mux.ServeHTTP(testRecorder, httptest.NewRequest("GET", "/api/v1/results/prometheus.io", nil))
+
+	var response struct {
+		Grade        string    `json:"grade"`
+		CacheExpiry  time.Time `json:"cache_expiry"`
+		Certificates []struct {
+			SerialNumber string `json:"serial_number"`
+		} `json:"certificates"`
+	}
+
+	if err := json.NewDecoder(testRecorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Grade != "A+" || response.CacheExpiry.IsZero() || len(response.Certificates) != 1 {
+		t.Errorf("Unexpected cached result : %+v", response)
+	}
+}
+
+func TestAuthenticated(t *testing.T) {
+	var cases = []struct {
+		name           string
+		cfg            config.AuthConfig
+		token          string
+		expectedStatus int
+	}{
+		{
+			name:           "no_credentials_configured",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "missing_credentials",
+			cfg:            config.AuthConfig{BearerToken: "secret"},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "wrong_credentials",
+			cfg:            config.AuthConfig{BearerToken: "secret"},
+			token:          "guess",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "valid_credentials",
+			cfg:            config.AuthConfig{BearerToken: "secret"},
+			token:          "secret",
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/api/v1/results", nil)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+
+		testRecorder := httptest.NewRecorder()
+		authenticated(log.Nop(), &c.cfg, func(w http.ResponseWriter, r *http.Request) {})(testRecorder, req)
+
+		if status := testRecorder.Code; status != c.expectedStatus {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedStatus, status)
+		}
+	}
+}
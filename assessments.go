@@ -0,0 +1,375 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	ssllabsApi "github.com/essentialkaos/sslscan/v13"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/exporter"
+	"github.com/anas-aso/ssllabs_exporter/internal/ssllabs"
+)
+
+// how frequently an assessment waiting for another exporter instance checks if it finished
+var lockPollInterval = time.Second
+
+var (
+	assessmentElapsedDesc = prometheus.NewDesc(
+		"ssllabs_exporter_assessment_elapsed_seconds",
+		"Time elapsed since the in-progress assessment started",
+		[]string{"target"}, nil,
+	)
+	assessmentStatusDesc = prometheus.NewDesc(
+		"ssllabs_exporter_assessment_status",
+		"SSLLabs status of the in-progress assessment",
+		[]string{"target", "status"}, nil,
+	)
+	endpointProgressDesc = prometheus.NewDesc(
+		"ssllabs_exporter_assessment_endpoint_progress_percent",
+		"Progress of the in-progress assessment endpoint, -1 if not started yet",
+		[]string{"target", "endpoint"}, nil,
+	)
+	endpointETADesc = prometheus.NewDesc(
+		"ssllabs_exporter_assessment_endpoint_eta_seconds",
+		"Estimated time until the in-progress assessment endpoint completes",
+		[]string{"target", "endpoint"}, nil,
+	)
+	endpointDurationDesc = prometheus.NewDesc(
+		"ssllabs_exporter_assessment_endpoint_duration_seconds",
+		"Time SSLLabs spent assessing the in-progress assessment endpoint",
+		[]string{"target", "endpoint"}, nil,
+	)
+	endpointPhaseDesc = prometheus.NewDesc(
+		"ssllabs_exporter_assessment_endpoint_phase",
+		"Operation SSLLabs is running on the in-progress assessment endpoint",
+		[]string{"target", "endpoint", "phase"}, nil,
+	)
+)
+
+// assessment is an SSLLabs assessment running in the background
+type assessment struct {
+	target string
+
+	// when the assessment was started
+	start time.Time
+
+	// ignore SSLLabs cached results
+	startNew bool
+
+	// closed once the assessment results are available
+	done chan struct{}
+
+	// assessment results, only valid after done is closed
+	result *exporter.Result
+
+	// captured logs of the assessment
+	logs   *logBuffer
+	logger log.Logger
+
+	// trace of the request which started the assessment
+	spanContext trace.SpanContext
+
+	// latest status reported by SSLLabs, nil until the assessment is submitted
+	mu     sync.Mutex
+	status *ssllabsApi.AnalyzeInfo
+}
+
+// assessments keeps track of the in-progress assessments per target so that
+// a probe which timed out can be resumed by the next one instead of starting over
+type assessments struct {
+	mu sync.Mutex
+
+	// in-progress assessments indexed by target
+	running map[string]*assessment
+
+	// parent context of all the assessments, canceled to abort them
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// used to wait for the in-progress assessments on shutdown
+	wg sync.WaitGroup
+
+	// how long an assessment can run regardless of the probes waiting for it
+	timeout time.Duration
+
+	// finished assessments are stored in the cache even if no probe is waiting for them
+	cache        cache
+	ignoreFailed bool
+
+	handle func(ctx context.Context, logger log.Logger, target string) *exporter.Result
+
+	// notified about the changes between consecutive assessments, nil if none is configured
+	webhooks *webhooks
+
+	// labels of the discovered targets added to their metrics, nil if no discovery source is configured
+	labels func(target string) map[string]string
+
+	logger log.Logger
+
+	// destination of the exporter logs, filtered by the configured log level,
+	// the assessments logs are written to it in addition to being captured
+	logOutput io.Writer
+}
+
+// start an assessment for the target or return the one already in progress.
+// The assessment is traced as part of the request context trace.
+func (a *assessments) start(ctx context.Context, target string) *assessment {
+	return a.startAssessment(ctx, target, false)
+}
+
+// start a new SSLLabs assessment for the target, ignoring SSLLabs cached results,
+// or return the one already in progress
+func (a *assessments) refresh(ctx context.Context, target string) *assessment {
+	return a.startAssessment(ctx, target, true)
+}
+
+func (a *assessments) startAssessment(ctx context.Context, target string, startNew bool) *assessment {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if running, found := a.running[target]; found {
+		a.logger.Debug().Str("target", target).Time("start", running.start).Msg("resuming in-progress assessment")
+		trace.SpanFromContext(ctx).AddEvent("resuming in-progress assessment", trace.WithAttributes(attribute.String("trace_id", running.spanContext.TraceID().String())))
+		return running
+	}
+
+	as := &assessment{
+		target:      target,
+		start:       time.Now(),
+		startNew:    startNew,
+		done:        make(chan struct{}),
+		logs:        &logBuffer{},
+		spanContext: trace.SpanContextFromContext(ctx),
+	}
+	as.logger = captureLogger(a.logOutput, as.logs)
+	a.running[target] = as
+
+	a.wg.Add(1)
+	go a.run(as)
+
+	return as
+}
+
+// run the assessment and store its results once finished
+func (a *assessments) run(as *assessment) {
+	defer a.wg.Done()
+
+	ctx, cancel := context.WithTimeout(a.ctx, a.timeout)
+	defer cancel()
+
+	// the assessment is part of the trace of the request which started it, even if it outlives it
+	ctx, span := tracer().Start(trace.ContextWithSpanContext(ctx, as.spanContext), "assessment",
+		trace.WithAttributes(attribute.String("target", as.target), attribute.Bool("start_new", as.startNew)))
+	defer span.End()
+
+	if as.startNew {
+		ctx = ssllabs.WithStartNew(ctx)
+	}
+	ctx = ssllabs.WithProgress(ctx, as.setStatus)
+
+	as.result = a.assess(ctx, as)
+	setResultAttributes(span, as.result)
+
+	a.mu.Lock()
+	delete(a.running, as.target)
+	a.mu.Unlock()
+
+	close(as.done)
+}
+
+// assess the target and cache its results, unless another exporter instance sharing
+// the cache is already assessing it, in which case its results are used instead
+func (a *assessments) assess(ctx context.Context, as *assessment) *exporter.Result {
+	previous, _ := a.cache.lookup(as.target)
+
+	unlock, acquired := a.cache.lock(as.target, a.timeout)
+	if !acquired {
+		as.logger.Debug().Str("target", as.target).Msg("waiting for the assessment of another instance")
+	}
+
+	for !acquired {
+		timer := time.NewTimer(lockPollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return exporter.Interrupted(as.target, as.start, ctx.Err())
+		case <-timer.C:
+		}
+
+		unlock, acquired = a.cache.lock(as.target, a.timeout)
+	}
+	defer unlock()
+
+	// the lock is released once the other instance cached its results, if it did.
+	// The results cached before the assessment started are never reused.
+	if cached, found := a.cache.lookup(as.target); found && (previous.result == nil || cached.result.Start.After(previous.result.Start)) {
+		as.logger.Debug().Str("target", as.target).Msg("using the assessment of another instance")
+		return cached.result
+	}
+
+	result := a.handle(exporter.WithLabels(ctx, a.targetLabels(as.target)), as.logger, as.target)
+	a.recordHistory(as.logger, as.target, result)
+
+	// do not cache failed assessments if configured or if they were aborted
+	// on shutdown, since these are not related to the target itself
+	failed := result.Err != nil
+	if !failed || !a.ignoreFailed && a.ctx.Err() == nil {
+		a.cache.add(as.target, result)
+	}
+
+	return result
+}
+
+// labels added to the target metrics by the target discovery
+func (a *assessments) targetLabels(target string) map[string]string {
+	if a.labels == nil {
+		return nil
+	}
+
+	return a.labels(target)
+}
+
+// shutdown waits for the in-progress assessments to finish until the context
+// is done, then aborts the remaining ones
+func (a *assessments) shutdown(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+
+	// SSLLabs keeps running the aborted assessments and they will be
+	// picked up by the next probe of the same targets instead of starting over
+	a.logger.Warn().Strs("targets", a.targets()).Msg("aborting in-progress assessments")
+	a.cancel()
+	<-done
+}
+
+// list the targets of the in-progress assessments
+func (a *assessments) targets() []string {
+	var targets []string
+	for _, as := range a.list() {
+		targets = append(targets, as.target)
+	}
+
+	return targets
+}
+
+// list the in-progress assessments sorted by target
+func (a *assessments) list() []*assessment {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	running := make([]*assessment, 0, len(a.running))
+	for _, as := range a.running {
+		running = append(running, as)
+	}
+
+	sort.Slice(running, func(i, j int) bool {
+		return running[i].target < running[j].target
+	})
+
+	return running
+}
+
+// keep the latest assessment status reported by SSLLabs
+func (as *assessment) setStatus(info *ssllabsApi.AnalyzeInfo) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	as.status = info
+}
+
+func (as *assessment) getStatus() *ssllabsApi.AnalyzeInfo {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	return as.status
+}
+
+// Describe implements prometheus.Collector
+func (a *assessments) Describe(ch chan<- *prometheus.Desc) {
+	ch <- assessmentElapsedDesc
+	ch <- assessmentStatusDesc
+	ch <- endpointProgressDesc
+	ch <- endpointETADesc
+	ch <- endpointDurationDesc
+	ch <- endpointPhaseDesc
+}
+
+// Collect implements prometheus.Collector, exposing the progress of the in-progress assessments
+func (a *assessments) Collect(ch chan<- prometheus.Metric) {
+	for _, as := range a.list() {
+		ch <- prometheus.MustNewConstMetric(assessmentElapsedDesc, prometheus.GaugeValue, time.Since(as.start).Seconds(), as.target)
+
+		status := as.getStatus()
+		if status == nil {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(assessmentStatusDesc, prometheus.GaugeValue, 1, as.target, status.Status)
+
+		for _, e := range status.Endpoints {
+			ch <- prometheus.MustNewConstMetric(endpointProgressDesc, prometheus.GaugeValue, float64(e.Progress), as.target, e.IPAddress)
+			ch <- prometheus.MustNewConstMetric(endpointETADesc, prometheus.GaugeValue, float64(e.ETA), as.target, e.IPAddress)
+			ch <- prometheus.MustNewConstMetric(endpointDurationDesc, prometheus.GaugeValue, (time.Duration(e.Duration) * time.Millisecond).Seconds(), as.target, e.IPAddress)
+			if e.StatusDetails != "" {
+				ch <- prometheus.MustNewConstMetric(endpointPhaseDesc, prometheus.GaugeValue, 1, as.target, e.IPAddress, e.StatusDetails)
+			}
+		}
+	}
+}
+
+// wait for the assessment results until the context is done
+func (as *assessment) wait(ctx context.Context) *exporter.Result {
+	select {
+	case <-as.done:
+		return as.result
+	case <-ctx.Done():
+		return exporter.Interrupted(as.target, as.start, ctx.Err())
+	}
+}
+
+// create a new assessments tracker
+func newAssessments(ctx context.Context, logger log.Logger, timeout time.Duration, resultsCache cache, ignoreFailed bool) *assessments {
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &assessments{
+		running:      make(map[string]*assessment),
+		ctx:          ctx,
+		cancel:       cancel,
+		timeout:      timeout,
+		cache:        resultsCache,
+		ignoreFailed: ignoreFailed,
+		handle:       exporter.Handle,
+		logger:       logger,
+		logOutput:    io.Discard,
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	ssllabsApi "github.com/essentialkaos/sslscan/v13"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/rs/zerolog"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/exporter"
+)
+
+func TestAssessmentsResume(t *testing.T) {
+	resultsCache := newMemoryCache(time.Minute, newRetentionPolicy(time.Minute), 0, 0, 0)
+	running := newAssessments(context.Background(), log.Nop(), time.Minute, resultsCache, true)
+
+	// fake a slow assessment that finishes only when asked to
+	release := make(chan struct{})
+	calls := 0
+	running.handle = func(ctx context.Context, logger log.Logger, target string) *exporter.Result {
+		calls++
+		<-release
+		return &exporter.Result{Target: target, Registry: prometheus.NewRegistry()}
+	}
+
+	target := "testDomain"
+
+	// the first probe times out before the assessment finishes
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	first := running.start(context.Background(), target)
+	if result := first.wait(ctx); result.Err == nil || !exporter.Failed(result.Registry) {
+		t.Errorf("Interrupted probe should report a failed assessment")
+	}
+
+	// the next probe picks up the in-progress assessment
+	second := running.start(context.Background(), target)
+	if first != second {
+		t.Errorf("In-progress assessment was not resumed")
+	}
+
+	close(release)
+	second.wait(context.Background())
+
+	if calls != 1 {
+		t.Errorf("Assessment executed more than once.\nExpected : %v\nGot : %v\n", 1, calls)
+	}
+
+	// results are cached even though the first probe is gone
+	if resultsCache.get(target) == nil {
+		t.Errorf("Finished assessment was not cached")
+	}
+
+	if len(running.running) != 0 {
+		t.Errorf("Finished assessment is still tracked as running")
+	}
+}
+
+func TestAssessmentsIgnoreFailed(t *testing.T) {
+	resultsCache := newMemoryCache(time.Minute, newRetentionPolicy(time.Minute), 0, 0, 0)
+	running := newAssessments(context.Background(), log.Nop(), time.Minute, resultsCache, true)
+	running.handle = func(ctx context.Context, logger log.Logger, target string) *exporter.Result {
+		return exporter.Interrupted(target, time.Now(), context.DeadlineExceeded)
+	}
+
+	target := "testDomain"
+	running.start(context.Background(), target).wait(context.Background())
+
+	if resultsCache.get(target) != nil {
+		t.Errorf("Failed assessment was cached")
+	}
+}
+
+func TestAssessmentsStaleCache(t *testing.T) {
+	resultsCache := newMemoryCache(time.Minute, newRetentionPolicy(time.Hour), 0, 0, 0)
+	running := newAssessments(context.Background(), log.Nop(), time.Minute, resultsCache, false)
+
+	calls := 0
+	running.handle = func(ctx context.Context, logger log.Logger, target string) *exporter.Result {
+		calls++
+		return gradedResult(target, "B", time.Now())
+	}
+
+	// the entry cached before the assessment started is not reused
+	target := "testDomain"
+	resultsCache.add(target, gradedResult(target, "A", time.Now().Add(-10*time.Minute)))
+
+	result := running.start(context.Background(), target).wait(context.Background())
+
+	if calls != 1 {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "stale_entry_calls", 1, calls)
+	}
+	if result.Grade() != "B" {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "stale_entry_grade", "B", result.Grade())
+	}
+}
+
+func TestAssessmentsShutdown(t *testing.T) {
+	resultsCache := newMemoryCache(time.Minute, newRetentionPolicy(time.Minute), 0, 0, 0)
+	running := newAssessments(context.Background(), log.Nop(), time.Minute, resultsCache, false)
+
+	// fake an assessment that only stops when aborted
+	running.handle = func(ctx context.Context, logger log.Logger, target string) *exporter.Result {
+		<-ctx.Done()
+		return exporter.Interrupted(target, time.Now(), ctx.Err())
+	}
+
+	target := "testDomain"
+	as := running.start(context.Background(), target)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	running.shutdown(ctx)
+
+	select {
+	case <-as.done:
+	default:
+		t.Errorf("In-progress assessment was not aborted on shutdown")
+	}
+
+	// aborted assessments are not related to the target and should not be cached
+	if resultsCache.get(target) != nil {
+		t.Errorf("Aborted assessment was cached")
+	}
+}
+
+func TestAssessmentsProgressMetrics(t *testing.T) {
+	resultsCache := newMemoryCache(time.Minute, newRetentionPolicy(time.Minute), 0, 0, 0)
+	running := newAssessments(context.Background(), log.Nop(), time.Minute, resultsCache, false)
+
+	release := make(chan struct{})
+	running.handle = func(ctx context.Context, logger log.Logger, target string) *exporter.Result {
+		<-release
+		return &exporter.Result{Target: target, Registry: prometheus.NewRegistry()}
+	}
+
+	as := running.start(context.Background(), "example.com")
+	as.setStatus(&ssllabsApi.AnalyzeInfo{
+		Status: ssllabsApi.STATUS_IN_PROGRESS,
+		Endpoints: []*ssllabsApi.EndpointInfo{
+			{IPAddress: "192.0.2.1", Progress: 42, ETA: 60, Duration: 30000, StatusDetails: "TESTING_PROTOCOL_INTOLERANCE_399"},
+			{IPAddress: "192.0.2.2", Progress: -1, ETA: -1},
+		},
+	})
+
+	expected := `
+# HELP ssllabs_exporter_assessment_endpoint_eta_seconds Estimated time until the in-progress assessment endpoint completes
+# TYPE ssllabs_exporter_assessment_endpoint_eta_seconds gauge
+ssllabs_exporter_assessment_endpoint_eta_seconds{endpoint="192.0.2.1",target="example.com"} 60
+ssllabs_exporter_assessment_endpoint_eta_seconds{endpoint="192.0.2.2",target="example.com"} -1
+# HELP ssllabs_exporter_assessment_endpoint_phase Operation SSLLabs is running on the in-progress assessment endpoint
+# TYPE ssllabs_exporter_assessment_endpoint_phase gauge
+ssllabs_exporter_assessment_endpoint_phase{endpoint="192.0.2.1",phase="TESTING_PROTOCOL_INTOLERANCE_399",target="example.com"} 1
+# HELP ssllabs_exporter_assessment_endpoint_progress_percent Progress of the in-progress assessment endpoint, -1 if not started yet
+# TYPE ssllabs_exporter_assessment_endpoint_progress_percent gauge
+ssllabs_exporter_assessment_endpoint_progress_percent{endpoint="192.0.2.1",target="example.com"} 42
+ssllabs_exporter_assessment_endpoint_progress_percent{endpoint="192.0.2.2",target="example.com"} -1
+# HELP ssllabs_exporter_assessment_status SSLLabs status of the in-progress assessment
+# TYPE ssllabs_exporter_assessment_status gauge
+ssllabs_exporter_assessment_status{status="IN_PROGRESS",target="example.com"} 1
+`
+
+	err := testutil.CollectAndCompare(running, strings.NewReader(expected),
+		"ssllabs_exporter_assessment_endpoint_eta_seconds",
+		"ssllabs_exporter_assessment_endpoint_phase",
+		"ssllabs_exporter_assessment_endpoint_progress_percent",
+		"ssllabs_exporter_assessment_status",
+	)
+	if err != nil {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "in_progress_metrics", nil, err)
+	}
+
+	close(release)
+	as.wait(context.Background())
+
+	// finished assessments are not exposed anymore
+	if count := testutil.CollectAndCount(running); count != 0 {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "finished_assessment", 0, count)
+	}
+}
@@ -16,12 +16,56 @@ package main
 
 import (
 	"container/list"
+	"encoding/json"
+	"slices"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/exporter"
 )
 
+// estimated memory used by a cache entry besides the SSLLabs assessment
+// (Prometheus registry, list element, etc)
+const cacheEntryOverhead = 4096
+
+// reasons of cache entries evictions
+const (
+	evictionExpired  = "expired"
+	evictionCapacity = "capacity"
+	evictionRemoved  = "removed"
+)
+
+// cache stores the assessments results shared by the probes
+type cache interface {
+	// add a new cache entry or update it if already exists
+	add(id string, result *exporter.Result)
+	// remove a cache entry and the target history, returns whether the entry existed
+	remove(id string) bool
+	// retrieve a cache entry if exists, otherwise return nil
+	get(id string) *exporter.Result
+	// retrieve a cache entry with its expiry time without counting it as a probe
+	lookup(id string) (cachedResult, bool)
+	// list the cache entries sorted by id
+	list() []cachedResult
+
+	// append a record to the target history, only the latest ones are kept
+	addHistory(id string, record exporter.Snapshot)
+	// list the target history from the oldest to the latest record
+	history(id string) []exporter.Snapshot
+
+	// lock prevents assessing the same target concurrently from different
+	// exporter instances, unlock must be called if the lock is acquired
+	lock(id string, ttl time.Duration) (unlock func(), acquired bool)
+
+	// release the cache resources
+	stop()
+
+	prometheus.Collector
+}
+
 // cacheEntry contains cache elements meta data
 type cacheEntry struct {
 	// the target host is used as a unique cache entry identifier
@@ -29,102 +73,426 @@ type cacheEntry struct {
 
 	// expiry time for the cache entry (calculated on creation time)
 	expiryTime int64
+
+	// estimated memory used by the entry in bytes
+	size int64
+
+	result *exporter.Result
 }
 
-type cache struct {
+// memoryCache is a cache local to the exporter instance
+type memoryCache struct {
 	mu sync.Mutex
 
-	// map of cached Prometheus registry for a fast access
-	entries map[string]*prometheus.Gatherer
+	// map of cached entries list elements for a fast access
+	entries map[string]*list.Element
 
-	// a linked ordered list for a faster cache retention
+	// a linked list ordered from the least to the most recently used entry
 	lru *list.List
 
 	// how long each cache entry should be kept
-	retention time.Duration
+	retention *retentionPolicy
 
 	// how frequent the cache retention is verified/applied
 	pruneDelay time.Duration
+
+	// limits of the cache entries count and estimated size, 0 means unlimited
+	maxEntries int
+	maxBytes   int64
+
+	// estimated memory used by all the entries in bytes
+	size int64
+
+	// latest assessments of each target. The histories of the expired entries
+	// are kept to detect the changes of the next assessments, and evicted
+	// from the least recently expired first to respect the cache limits.
+	histories   map[string]*targetHistory
+	expired     *list.List
+	historySize int
+
+	// estimated memory used by all the histories in bytes
+	historyBytes int64
+
+	hits      uint64
+	misses    uint64
+	evictions map[string]uint64
+
+	// closed to stop the retention worker
+	done chan struct{}
+}
+
+// targetHistory contains the latest assessments of a target
+type targetHistory struct {
+	records []exporter.Snapshot
+
+	// estimated memory used by the records in bytes
+	size int64
+
+	// element of the expired histories list if the target is not cached
+	expired *list.Element
+}
+
+// cachedResult is a snapshot of a cache entry
+type cachedResult struct {
+	result     *exporter.Result
+	expiryTime time.Time
 }
 
-// add a new cache entry or update it if already exists
-func (c *cache) add(id string, result prometheus.Gatherer) {
+var (
+	cacheEntriesDesc = prometheus.NewDesc(
+		"ssllabs_exporter_cache_entries",
+		"Number of assessment results in the cache",
+		nil, nil,
+	)
+	cacheSizeDesc = prometheus.NewDesc(
+		"ssllabs_exporter_cache_size_bytes",
+		"Estimated memory used by the cached assessment results and histories",
+		nil, nil,
+	)
+	cacheHitsDesc = prometheus.NewDesc(
+		"ssllabs_exporter_cache_hits_total",
+		"Number of probes served from the cache",
+		nil, nil,
+	)
+	cacheMissesDesc = prometheus.NewDesc(
+		"ssllabs_exporter_cache_misses_total",
+		"Number of probes not found in the cache",
+		nil, nil,
+	)
+	cacheEvictionsDesc = prometheus.NewDesc(
+		"ssllabs_exporter_cache_evictions_total",
+		"Number of entries removed from the cache by reason",
+		[]string{"reason"}, nil,
+	)
+)
+
+// add a new cache entry or update it if already exists.
+// Results without retention are not cached, and replace the previous entry.
+func (c *memoryCache) add(id string, result *exporter.Result) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	ttl := c.retention.ttl(result)
+	if ttl <= 0 {
+		// the previous result is outdated, but its history is kept like an expired entry
+		if e, found := c.entries[id]; found {
+			c.removeElement(e, evictionExpired)
+		}
+		return
+	}
+
 	entry := &cacheEntry{
 		id:         id,
-		expiryTime: int64(c.retention.Seconds()) + time.Now().Unix(),
-	}
-
-	_, alreadyExists := c.entries[id]
-	if alreadyExists {
-		e := c.lru.Front()
-		for e != nil {
-			if e.Value.(*cacheEntry).id == id {
-				c.lru.MoveToBack(e)
-				break
-			}
-			e = e.Next()
-		}
+		expiryTime: time.Now().Add(ttl).Unix(),
+		size:       resultSize(result),
+		result:     result,
+	}
+
+	if e, alreadyExists := c.entries[id]; alreadyExists {
+		c.size -= e.Value.(*cacheEntry).size
+		e.Value = entry
+		c.lru.MoveToBack(e)
 	} else {
-		c.lru.PushBack(entry)
+		c.entries[id] = c.lru.PushBack(entry)
+	}
+	c.size += entry.size
+
+	// the target history is kept with its cache entry
+	if h, found := c.histories[id]; found && h.expired != nil {
+		c.expired.Remove(h.expired)
+		h.expired = nil
 	}
 
-	c.entries[id] = &result
+	c.shrink()
 }
 
-// retrieve a cache entry if exists, otherwise return nil
-func (c *cache) get(id string) prometheus.Gatherer {
+// check whether the entries and the histories exceed the cache limits,
+// the lock must be held by the caller
+func (c *memoryCache) full() bool {
+	return c.maxEntries > 0 && c.lru.Len()+c.expired.Len() > c.maxEntries ||
+		c.maxBytes > 0 && c.size+c.historyBytes > c.maxBytes
+}
+
+// evict the histories of the expired entries then the least recently used entries
+// until the cache limits are respected. An entry exceeding the size limit on its own
+// is still cached. The lock must be held by the caller.
+func (c *memoryCache) shrink() {
+	for c.full() {
+		if e := c.expired.Front(); e != nil {
+			c.removeHistory(e.Value.(string))
+			continue
+		}
+
+		if c.lru.Len() <= 1 {
+			return
+		}
+		c.removeElement(c.lru.Front(), evictionCapacity)
+	}
+}
+
+// remove a cache entry and the target history, returns whether the entry existed
+func (c *memoryCache) remove(id string) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	result, found := c.entries[id]
+	e, found := c.entries[id]
 	if found {
-		return *result
+		c.removeElement(e, evictionRemoved)
 	}
+	c.removeHistory(id)
 
-	return nil
+	return found
 }
 
-// prune expired entries from the cache
-func (c *cache) prune() {
+// remove a list element and its entry, the lock must be held by the caller
+func (c *memoryCache) removeElement(e *list.Element, reason string) {
+	entry := e.Value.(*cacheEntry)
+
+	c.lru.Remove(e)
+	delete(c.entries, entry.id)
+	c.size -= entry.size
+	c.evictions[reason]++
+
+	// the history of an expired entry is kept for the next assessment
+	h, found := c.histories[entry.id]
+	switch {
+	case !found:
+	case reason == evictionExpired:
+		h.expired = c.expired.PushBack(entry.id)
+	default:
+		c.removeHistory(entry.id)
+	}
+}
+
+// remove a target history and its metrics, the lock must be held by the caller
+func (c *memoryCache) removeHistory(id string) {
+	h, found := c.histories[id]
+	if !found {
+		return
+	}
+
+	if h.expired != nil {
+		c.expired.Remove(h.expired)
+	}
+	delete(c.histories, id)
+	c.historyBytes -= h.size
+
+	forgetHistory(id)
+}
+
+// retrieve a cache entry if exists, otherwise return nil
+func (c *memoryCache) get(id string) *exporter.Result {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	e := c.lru.Front()
+	e, found := c.entries[id]
+	if !found {
+		c.misses++
+		return nil
+	}
 
-	for e != nil {
-		entry := e.Value.(*cacheEntry)
+	c.hits++
+	c.lru.MoveToBack(e)
+
+	return e.Value.(*cacheEntry).result
+}
+
+// retrieve a cache entry with its expiry time if exists,
+// without marking it as recently used
+func (c *memoryCache) lookup(id string) (cachedResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, found := c.entries[id]
+	if !found {
+		return cachedResult{}, false
+	}
 
-		// since the list is ordered, we can stop the iteration once a fresh element is found
-		if entry.expiryTime > time.Now().Unix() {
-			break
+	return e.Value.(*cacheEntry).snapshot(), true
+}
+
+// list the cache entries sorted by id
+func (c *memoryCache) list() []cachedResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	results := make([]cachedResult, 0, c.lru.Len())
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		results = append(results, e.Value.(*cacheEntry).snapshot())
+	}
+
+	sortResults(results)
+
+	return results
+}
+
+// sort the cached results by target
+func sortResults(results []cachedResult) {
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].result.Target < results[j].result.Target
+	})
+}
+
+// append a record to the target history, only the latest ones are kept
+func (c *memoryCache) addHistory(id string, record exporter.Snapshot) {
+	if c.historySize <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, found := c.histories[id]
+	if !found {
+		h = &targetHistory{}
+		c.histories[id] = h
+
+		// the history of a target without cache entry is evicted first
+		if _, cached := c.entries[id]; !cached {
+			h.expired = c.expired.PushBack(id)
 		}
+	}
+
+	h.records = append(h.records, record)
+	if len(h.records) > c.historySize {
+		// copy the kept records to release the older ones
+		h.records = slices.Clone(h.records[len(h.records)-c.historySize:])
+	}
+
+	c.historyBytes -= h.size
+	h.size = 0
+	for _, r := range h.records {
+		h.size += snapshotSize(r)
+	}
+	c.historyBytes += h.size
+
+	c.shrink()
+}
 
+// list the target history from the oldest to the latest record
+func (c *memoryCache) history(id string) []exporter.Snapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, found := c.histories[id]
+	if !found {
+		return nil
+	}
+
+	return slices.Clone(h.records)
+}
+
+// the assessments of the same target are already deduplicated by the exporter instance
+func (c *memoryCache) lock(string, time.Duration) (func(), bool) {
+	return func() {}, true
+}
+
+func (entry *cacheEntry) snapshot() cachedResult {
+	return cachedResult{
+		result:     entry.result,
+		expiryTime: time.Unix(entry.expiryTime, 0),
+	}
+}
+
+// prune expired entries from the cache
+func (c *memoryCache) prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now().Unix()
+
+	// the list is ordered by usage, not expiry time, so all the entries are checked
+	e := c.lru.Front()
+	for e != nil {
 		next := e.Next()
-		c.lru.Remove(e)
-		delete(c.entries, entry.id)
+		if e.Value.(*cacheEntry).expiryTime <= now {
+			c.removeElement(e, evictionExpired)
+		}
 		e = next
 	}
 }
 
+// Describe implements prometheus.Collector
+func (c *memoryCache) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheEntriesDesc
+	ch <- cacheSizeDesc
+	ch <- cacheHitsDesc
+	ch <- cacheMissesDesc
+	ch <- cacheEvictionsDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *memoryCache) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(cacheEntriesDesc, prometheus.GaugeValue, float64(c.lru.Len()))
+	ch <- prometheus.MustNewConstMetric(cacheSizeDesc, prometheus.GaugeValue, float64(c.size+c.historyBytes))
+	ch <- prometheus.MustNewConstMetric(cacheHitsDesc, prometheus.CounterValue, float64(c.hits))
+	ch <- prometheus.MustNewConstMetric(cacheMissesDesc, prometheus.CounterValue, float64(c.misses))
+	for _, reason := range []string{evictionExpired, evictionCapacity, evictionRemoved} {
+		ch <- prometheus.MustNewConstMetric(cacheEvictionsDesc, prometheus.CounterValue, float64(c.evictions[reason]), reason)
+	}
+}
+
+// estimate the memory used by an assessment result
+func resultSize(result *exporter.Result) int64 {
+	size := int64(cacheEntryOverhead)
+
+	if result.Info != nil {
+		// the JSON encoding is a good enough approximation of the assessment size
+		if content, err := json.Marshal(result.Info); err == nil {
+			size += int64(len(content))
+		}
+	}
+
+	return size
+}
+
+// estimate the memory used by an assessment history record
+func snapshotSize(record exporter.Snapshot) int64 {
+	// the JSON encoding is a good enough approximation of the record size
+	content, _ := json.Marshal(record)
+
+	return int64(len(content))
+}
+
 // start a time ticker to remove expired cache entries
-func (c *cache) start() {
+func (c *memoryCache) start() {
 	ticker := time.NewTicker(c.pruneDelay)
+	defer ticker.Stop()
 
-	for range ticker.C {
-		c.prune()
+	for {
+		select {
+		case <-ticker.C:
+			c.prune()
+		case <-c.done:
+			return
+		}
 	}
 }
 
-// create a new cache and start the retention worker in the background
-func newCache(pruneDelay, retention time.Duration) *cache {
-	c := &cache{
-		entries:    make(map[string]*prometheus.Gatherer),
-		lru:        list.New(),
-		retention:  retention,
-		pruneDelay: pruneDelay,
+// stop the retention worker
+func (c *memoryCache) stop() {
+	close(c.done)
+}
+
+// create a new cache and start the retention worker in the background.
+// maxEntries and maxBytes limit the cache size, 0 means unlimited.
+// historySize is the number of assessments kept per target, 0 disables the history.
+func newMemoryCache(pruneDelay time.Duration, retention *retentionPolicy, maxEntries int, maxBytes int64, historySize int) *memoryCache {
+	c := &memoryCache{
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+		retention:   retention,
+		pruneDelay:  pruneDelay,
+		maxEntries:  maxEntries,
+		maxBytes:    maxBytes,
+		histories:   make(map[string]*targetHistory),
+		expired:     list.New(),
+		historySize: historySize,
+		evictions:   make(map[string]uint64),
+		done:        make(chan struct{}),
 	}
 
 	go c.start()
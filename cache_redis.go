@@ -0,0 +1,440 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	ssllabsApi "github.com/essentialkaos/sslscan/v13"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	log "github.com/rs/zerolog"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/exporter"
+	"github.com/anas-aso/ssllabs_exporter/internal/ssllabs"
+)
+
+// how long a Redis request can take
+const redisTimeout = 5 * time.Second
+
+// delete the lock only if it is still owned by the caller
+var redisUnlockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`)
+
+var cacheErrorsDesc = prometheus.NewDesc(
+	"ssllabs_exporter_cache_errors_total",
+	"Number of failed requests to the shared cache",
+	nil, nil,
+)
+
+// redisCache is a cache shared by multiple exporter instances through a Redis server.
+// The entries expiry is handled by Redis and its memory limits apply instead of the cache ones.
+type redisCache struct {
+	client *redis.Client
+
+	// prefix of all the keys used by the exporter
+	prefix string
+
+	// how long each cache entry should be kept
+	retention *retentionPolicy
+
+	// number of assessments kept in the history of each target
+	historySize int
+
+	logger log.Logger
+
+	mu      sync.Mutex
+	hits    uint64
+	misses  uint64
+	removed uint64
+	errors  uint64
+}
+
+// redisEntry is the stored form of a cached result
+type redisEntry struct {
+	Target     string                  `json:"target"`
+	Start      time.Time               `json:"start"`
+	Duration   time.Duration           `json:"duration"`
+	Info       *ssllabsApi.AnalyzeInfo `json:"info,omitempty"`
+	Labels     map[string]string       `json:"labels,omitempty"`
+	Status     string                  `json:"status,omitempty"`
+	Error      string                  `json:"error,omitempty"`
+	ExpiryTime time.Time               `json:"expiry_time"`
+}
+
+func (c *redisCache) resultKey(id string) string {
+	return c.prefix + "result:" + id
+}
+
+func (c *redisCache) historyKey(id string) string {
+	return c.prefix + "history:" + id
+}
+
+func (c *redisCache) lockKey(id string) string {
+	return c.prefix + "lock:" + id
+}
+
+// add a new cache entry or update it if already exists.
+// Results without retention are not cached, and replace the previous entry.
+func (c *redisCache) add(id string, result *exporter.Result) {
+	ttl := c.retention.ttl(result)
+	if ttl <= 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+		defer cancel()
+
+		if err := c.client.Del(ctx, c.resultKey(id)).Err(); err != nil {
+			c.failed(err, id, "failed to remove the outdated cache entry")
+		}
+		return
+	}
+
+	entry := redisEntry{
+		Target:     result.Target,
+		Start:      result.Start,
+		Duration:   result.Duration,
+		Info:       result.Info,
+		Labels:     result.Labels,
+		ExpiryTime: time.Now().Add(ttl),
+	}
+	if result.Err != nil {
+		entry.Status = ssllabs.ErrorStatus(result.Err)
+		entry.Error = result.Err.Error()
+	}
+
+	content, err := json.Marshal(entry)
+	if err != nil {
+		c.failed(err, id, "failed to encode the cache entry")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	if err := c.client.Set(ctx, c.resultKey(id), content, ttl).Err(); err != nil {
+		c.failed(err, id, "failed to store the cache entry")
+	}
+}
+
+// remove a cache entry and the target history, returns whether the entry existed
+func (c *redisCache) remove(id string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	var deleted *redis.IntCmd
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.Del(ctx, c.resultKey(id))
+		pipe.Del(ctx, c.historyKey(id))
+		return nil
+	})
+	if err != nil {
+		c.failed(err, id, "failed to remove the cache entry")
+		return false
+	}
+
+	forgetHistory(id)
+
+	if deleted.Val() == 0 {
+		return false
+	}
+
+	c.mu.Lock()
+	c.removed++
+	c.mu.Unlock()
+
+	return true
+}
+
+// retrieve a cache entry if exists, otherwise return nil
+func (c *redisCache) get(id string) *exporter.Result {
+	entry, found := c.lookup(id)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !found {
+		c.misses++
+		return nil
+	}
+
+	c.hits++
+
+	return entry.result
+}
+
+// retrieve a cache entry with its expiry time if exists
+func (c *redisCache) lookup(id string) (cachedResult, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	content, err := c.client.Get(ctx, c.resultKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return cachedResult{}, false
+	}
+	if err != nil {
+		c.failed(err, id, "failed to fetch the cache entry")
+		return cachedResult{}, false
+	}
+
+	return c.decode(id, content)
+}
+
+// list the cache entries sorted by id
+func (c *redisCache) list() []cachedResult {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	keys, err := c.keys(ctx)
+	if err != nil {
+		c.failed(err, "", "failed to list the cache entries")
+		return nil
+	}
+
+	results := make([]cachedResult, 0, len(keys))
+	if len(keys) == 0 {
+		return results
+	}
+
+	values, err := c.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		c.failed(err, "", "failed to fetch the cache entries")
+		return results
+	}
+
+	for i, value := range values {
+		// the entry expired after being listed
+		content, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		if result, ok := c.decode(strings.TrimPrefix(keys[i], c.resultKey("")), []byte(content)); ok {
+			results = append(results, result)
+		}
+	}
+
+	sortResults(results)
+
+	return results
+}
+
+// list the keys of the cache entries
+func (c *redisCache) keys(ctx context.Context) ([]string, error) {
+	var keys []string
+
+	iter := c.client.Scan(ctx, 0, c.resultKey("*"), 0).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+
+	return keys, iter.Err()
+}
+
+// restore a cached result from its stored form
+func (c *redisCache) decode(id string, content []byte) (cachedResult, bool) {
+	var entry redisEntry
+	if err := json.Unmarshal(content, &entry); err != nil {
+		c.failed(err, id, "failed to decode the cache entry")
+		return cachedResult{}, false
+	}
+
+	var err error
+	if entry.Error != "" {
+		err = ssllabs.NewError(entry.Status, entry.Error)
+	}
+
+	result := exporter.NewResult(entry.Target, entry.Start, entry.Duration, entry.Info, err)
+	if len(entry.Labels) > 0 {
+		result = result.WithLabels(entry.Labels)
+	}
+
+	return cachedResult{
+		result:     result,
+		expiryTime: entry.ExpiryTime,
+	}, true
+}
+
+// append a record to the target history, only the latest ones are kept.
+// The history expires if the target isn't assessed again for as long as
+// the longest retention of its results times the history size.
+func (c *redisCache) addHistory(id string, record exporter.Snapshot) {
+	if c.historySize <= 0 {
+		return
+	}
+
+	content, err := json.Marshal(record)
+	if err != nil {
+		c.failed(err, id, "failed to encode the history record")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	_, err = c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, c.historyKey(id), content)
+		pipe.LTrim(ctx, c.historyKey(id), int64(-c.historySize), -1)
+		if ttl := c.retention.longest(id); ttl > 0 {
+			pipe.Expire(ctx, c.historyKey(id), ttl*time.Duration(c.historySize))
+		}
+		return nil
+	})
+	if err != nil {
+		c.failed(err, id, "failed to store the history record")
+	}
+}
+
+// list the target history from the oldest to the latest record
+func (c *redisCache) history(id string) []exporter.Snapshot {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	values, err := c.client.LRange(ctx, c.historyKey(id), 0, -1).Result()
+	if err != nil {
+		c.failed(err, id, "failed to fetch the history")
+		return nil
+	}
+
+	records := make([]exporter.Snapshot, 0, len(values))
+	for _, value := range values {
+		var record exporter.Snapshot
+		if err := json.Unmarshal([]byte(value), &record); err != nil {
+			c.failed(err, id, "failed to decode the history record")
+			continue
+		}
+		records = append(records, record)
+	}
+
+	return records
+}
+
+// acquire a lock expiring after the ttl in case the owner stops without releasing it.
+// If Redis is unavailable the lock is considered acquired to keep assessing the targets.
+func (c *redisCache) lock(id string, ttl time.Duration) (func(), bool) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		c.failed(err, id, "failed to generate the lock token")
+		return func() {}, true
+	}
+	value := hex.EncodeToString(token)
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	acquired, err := c.client.SetNX(ctx, c.lockKey(id), value, ttl).Result()
+	if err != nil {
+		c.failed(err, id, "failed to acquire the lock")
+		return func() {}, true
+	}
+
+	if !acquired {
+		return nil, false
+	}
+
+	unlock := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+		defer cancel()
+
+		if err := redisUnlockScript.Run(ctx, c.client, []string{c.lockKey(id)}, value).Err(); err != nil {
+			c.failed(err, id, "failed to release the lock")
+		}
+	}
+
+	return unlock, true
+}
+
+// record and log a failed cache operation
+func (c *redisCache) failed(err error, id, msg string) {
+	c.mu.Lock()
+	c.errors++
+	c.mu.Unlock()
+
+	c.logger.Error().Err(err).Str("target", id).Msg(msg)
+}
+
+// Describe implements prometheus.Collector
+func (c *redisCache) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheEntriesDesc
+	ch <- cacheHitsDesc
+	ch <- cacheMissesDesc
+	ch <- cacheEvictionsDesc
+	ch <- cacheErrorsDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *redisCache) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	if keys, err := c.keys(ctx); err != nil {
+		c.failed(err, "", "failed to count the cache entries")
+	} else {
+		ch <- prometheus.MustNewConstMetric(cacheEntriesDesc, prometheus.GaugeValue, float64(len(keys)))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(cacheHitsDesc, prometheus.CounterValue, float64(c.hits))
+	ch <- prometheus.MustNewConstMetric(cacheMissesDesc, prometheus.CounterValue, float64(c.misses))
+	// expired entries are removed by Redis
+	ch <- prometheus.MustNewConstMetric(cacheEvictionsDesc, prometheus.CounterValue, float64(c.removed), evictionRemoved)
+	ch <- prometheus.MustNewConstMetric(cacheErrorsDesc, prometheus.CounterValue, float64(c.errors))
+}
+
+// close the connections to the Redis server
+func (c *redisCache) stop() {
+	if err := c.client.Close(); err != nil {
+		c.logger.Error().Err(err).Msg("failed to close the cache connections")
+	}
+}
+
+// create a cache stored in the Redis server of the URL (redis://<user>:<password>@<host>:<port>/<db>)
+// and check the server is reachable. historySize is the number of assessments kept per target,
+// 0 disables the history.
+func newRedisCache(logger log.Logger, url, prefix string, retention *retentionPolicy, historySize int) (*redisCache, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &redisCache{
+		client:      redis.NewClient(options),
+		prefix:      prefix,
+		retention:   retention,
+		historySize: historySize,
+		logger:      logger,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	if err := c.client.Ping(ctx).Err(); err != nil {
+		c.client.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
@@ -0,0 +1,219 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"maps"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	ssllabsApi "github.com/essentialkaos/sslscan/v13"
+	log "github.com/rs/zerolog"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/exporter"
+	"github.com/anas-aso/ssllabs_exporter/internal/ssllabs"
+)
+
+// create a Redis cache backed by an in-process Redis server
+func newTestRedisCache(t *testing.T, retention time.Duration) (*redisCache, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+
+	c, err := newRedisCache(log.Nop(), "redis://"+server.Addr(), "test:", newRetentionPolicy(retention), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.stop)
+
+	return c, server
+}
+
+func TestRedisCacheAddGet(t *testing.T) {
+	c, server := newTestRedisCache(t, time.Minute)
+
+	start := time.Now().Add(-time.Minute).Truncate(time.Second)
+	info := &ssllabsApi.AnalyzeInfo{Host: "example.com", Endpoints: []*ssllabsApi.EndpointInfo{{Grade: "A"}}}
+
+	c.add("example.com", exporter.NewResult("example.com", start, 30*time.Second, info, nil))
+	c.add("example.org", exporter.NewResult("example.org", start, 30*time.Second, nil, ssllabs.NewError(ssllabs.StatusDNSError, "Unable to resolve domain name")))
+
+	result := c.get("example.com")
+	if result == nil {
+		t.Fatalf("Cached result not found")
+	}
+
+	if result.Target != "example.com" || !result.Start.Equal(start) || result.Duration != 30*time.Second || result.Grade() != "A" {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "restored_result", info, result)
+	}
+
+	failed := c.get("example.org")
+	if failed == nil || ssllabs.ErrorStatus(failed.Err) != ssllabs.StatusDNSError || !exporter.Failed(failed.Registry) {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "restored_failure", ssllabs.StatusDNSError, failed)
+	}
+
+	if c.get("404") != nil {
+		t.Errorf("Cache returns unexpected result for a missing entry")
+	}
+
+	var targets []string
+	for _, entry := range c.list() {
+		targets = append(targets, entry.result.Target)
+	}
+	if len(targets) != 2 || targets[0] != "example.com" || targets[1] != "example.org" {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "list", []string{"example.com", "example.org"}, targets)
+	}
+
+	if !c.remove("example.org") || c.remove("example.org") {
+		t.Errorf("Cache entry removal failed")
+	}
+
+	// the entries expiry is handled by Redis
+	server.FastForward(2 * time.Minute)
+	if c.get("example.com") != nil {
+		t.Errorf("Cache contains stale data")
+	}
+}
+
+func TestRedisCacheAddWithoutRetention(t *testing.T) {
+	c, _ := newTestRedisCache(t, time.Minute)
+	c.retention.failed = 0
+
+	c.add("example.com", gradedResult("example.com", "A", time.Now()))
+
+	// the failed result is not cached, and the previous one is outdated
+	c.add("example.com", exporter.Interrupted("example.com", time.Now(), context.DeadlineExceeded))
+	if result := c.get("example.com"); result != nil {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "outdated_entry", nil, result)
+	}
+}
+
+func TestRedisCacheHistoryExpiry(t *testing.T) {
+	c, server := newTestRedisCache(t, time.Minute)
+
+	c.addHistory("example.com", exporter.Snapshot{Grade: "A"})
+	c.addHistory("example.com", exporter.Snapshot{Grade: "B"})
+
+	// the history expires once the target isn't assessed for the retention times the history size
+	if ttl := server.TTL(c.historyKey("example.com")); ttl != 3*time.Minute {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "history_ttl", 3*time.Minute, ttl)
+	}
+
+	server.FastForward(2 * time.Minute)
+	if records := c.history("example.com"); len(records) != 2 {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "history_kept", 2, len(records))
+	}
+
+	server.FastForward(2 * time.Minute)
+	if records := c.history("example.com"); len(records) != 0 {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "history_expired", 0, len(records))
+	}
+}
+
+func TestRedisCacheLock(t *testing.T) {
+	c, server := newTestRedisCache(t, time.Minute)
+
+	unlock, acquired := c.lock("example.com", time.Minute)
+	if !acquired {
+		t.Fatalf("Lock was not acquired")
+	}
+
+	if _, acquired := c.lock("example.com", time.Minute); acquired {
+		t.Errorf("Lock was acquired twice")
+	}
+
+	// locks of other targets are independent
+	if _, acquired := c.lock("example.org", time.Minute); !acquired {
+		t.Errorf("Lock of another target was not acquired")
+	}
+
+	unlock()
+	if _, acquired := c.lock("example.com", time.Minute); !acquired {
+		t.Errorf("Lock was not released")
+	}
+
+	// locks of stopped instances expire
+	server.FastForward(2 * time.Minute)
+	if _, acquired := c.lock("example.com", time.Minute); !acquired {
+		t.Errorf("Lock did not expire")
+	}
+}
+
+func TestAssessmentsSharedCache(t *testing.T) {
+	lockPollInterval = 10 * time.Millisecond
+
+	server := miniredis.RunT(t)
+
+	// two exporter instances sharing the same Redis server
+	var instances []*assessments
+	for i := 0; i < 2; i++ {
+		c, err := newRedisCache(log.Nop(), "redis://"+server.Addr(), "test:", newRetentionPolicy(time.Minute), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(c.stop)
+
+		instances = append(instances, newAssessments(context.Background(), log.Nop(), time.Minute, c, false))
+	}
+
+	release := make(chan struct{})
+	calls := make(chan string, 2)
+	for _, running := range instances {
+		running.handle = func(ctx context.Context, logger log.Logger, target string) *exporter.Result {
+			calls <- target
+			<-release
+			return exporter.NewResult(target, time.Now(), time.Second, &ssllabsApi.AnalyzeInfo{}, nil)
+		}
+	}
+
+	first := instances[0].start(context.Background(), "example.com")
+	<-calls
+
+	// the second instance waits for the assessment of the first one
+	second := instances[1].start(context.Background(), "example.com")
+	close(release)
+
+	if result := second.wait(context.Background()); result == nil || result.Err != nil {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "shared_result", "successful result", result)
+	}
+	first.wait(context.Background())
+
+	if len(calls) != 0 {
+		t.Errorf("Target was assessed by both instances")
+	}
+}
+
+func TestRedisCacheLabels(t *testing.T) {
+	c, _ := newTestRedisCache(t, time.Minute)
+
+	labels := map[string]string{"team": "web"}
+	result := exporter.NewResult("example.com", time.Now(), time.Second, &ssllabsApi.AnalyzeInfo{}, nil).WithLabels(labels)
+	c.add("example.com", result)
+
+	restored := c.get("example.com")
+	if restored == nil || !maps.Equal(restored.Labels, labels) {
+		t.Fatalf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "restored_labels", labels, restored)
+	}
+
+	w := httptest.NewRecorder()
+	debugResponse(w, "", restored.Registry)
+	if !strings.Contains(w.Body.String(), `ssllabs_probe_success{team="web"} 1`) {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "restored_metrics_labels", labels, w.Body.String())
+	}
+}
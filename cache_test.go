@@ -15,17 +15,22 @@
 package main
 
 import (
+	"context"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/exporter"
 )
 
 func TestAddGet(t *testing.T) {
 	// initialize cache
 	pruneDelay := 1 * time.Minute
 	retention := 1 * time.Minute
-	cache := newCache(pruneDelay, retention)
+	cache := newMemoryCache(pruneDelay, newRetentionPolicy(retention), 0, 0, 0)
 
 	// create test registry
 	registry := prometheus.NewRegistry()
@@ -39,11 +44,11 @@ func TestAddGet(t *testing.T) {
 	})
 	registry.MustRegister(metric)
 
-	cache.add(entryID, prometheus.Gatherer(registry))
+	cache.add(entryID, &exporter.Result{Target: entryID, Registry: registry})
 
 	// fetch the cached entry and verify contents
 	entry := cache.get(entryID)
-	mfs, _ := entry.Gather()
+	mfs, _ := entry.Registry.Gather()
 
 	// check the content of the cached registry
 	if len(mfs) != 1 {
@@ -54,7 +59,7 @@ func TestAddGet(t *testing.T) {
 	}
 
 	// add 2nd entry
-	cache.add(entryID+"_2nd", prometheus.Gatherer(registry))
+	cache.add(entryID+"_2nd", &exporter.Result{Target: entryID + "_2nd", Registry: registry})
 	if cache.lru.Len() != 2 || len(cache.entries) != 2 {
 		var dupEntries []cacheEntry
 		for e := cache.lru.Front(); e != nil; e = e.Next() {
@@ -64,7 +69,7 @@ func TestAddGet(t *testing.T) {
 	}
 
 	// add a duplicate entry
-	cache.add(entryID+"_2nd", prometheus.Gatherer(registry))
+	cache.add(entryID+"_2nd", &exporter.Result{Target: entryID + "_2nd", Registry: registry})
 	if cache.lru.Len() != 2 || len(cache.entries) != 2 {
 		var dupEntries []cacheEntry
 		for e := cache.lru.Front(); e != nil; e = e.Next() {
@@ -80,11 +85,29 @@ func TestAddGet(t *testing.T) {
 	}
 }
 
+func TestAddWithoutRetention(t *testing.T) {
+	retention := newRetentionPolicy(time.Minute)
+	retention.failed = 0
+	cache := newMemoryCache(time.Minute, retention, 0, 0, 0)
+
+	entryID := "testDomain"
+	cache.add(entryID, &exporter.Result{Target: entryID, Registry: prometheus.NewRegistry()})
+
+	// the failed result is not cached, and the previous one is outdated
+	cache.add(entryID, exporter.Interrupted(entryID, time.Now(), context.DeadlineExceeded))
+	if entry := cache.get(entryID); entry != nil {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "outdated_entry", nil, entry)
+	}
+	if cache.lru.Len() != 0 || len(cache.entries) != 0 {
+		t.Errorf("Cache doesn't contain expected entries count.\nExpected : %v\nGot : %v\n", 0, len(cache.entries))
+	}
+}
+
 func TestPrune(t *testing.T) {
 	// initialize cache
 	pruneDelay := 1 * time.Second
 	retention := 2 * time.Second
-	cache := newCache(pruneDelay, retention)
+	cache := newMemoryCache(pruneDelay, newRetentionPolicy(retention), 0, 0, 0)
 
 	// create test registry
 	registry := prometheus.NewRegistry()
@@ -98,7 +121,7 @@ func TestPrune(t *testing.T) {
 	})
 	registry.MustRegister(metric)
 
-	cache.add(entryID, prometheus.Gatherer(registry))
+	cache.add(entryID, &exporter.Result{Target: entryID, Registry: registry})
 
 	// wait for the cache to expire
 	time.Sleep(retention + pruneDelay)
@@ -109,3 +132,135 @@ func TestPrune(t *testing.T) {
 		t.Errorf("Cache contains stale data")
 	}
 }
+
+func TestStop(t *testing.T) {
+	// initialize cache
+	pruneDelay := 1 * time.Second
+	retention := 1 * time.Second
+	cache := newMemoryCache(pruneDelay, newRetentionPolicy(retention), 0, 0, 0)
+
+	cache.add("testDomain", &exporter.Result{Target: "testDomain", Registry: prometheus.NewRegistry()})
+	cache.stop()
+
+	// wait for the cache to expire
+	time.Sleep(retention + 2*pruneDelay)
+
+	// the retention worker is stopped, so the expired entry is kept
+	if cache.get("testDomain") == nil {
+		t.Errorf("Cache retention worker is still running after stop")
+	}
+}
+
+func TestLRUEviction(t *testing.T) {
+	result := func(target string) *exporter.Result {
+		return &exporter.Result{Target: target, Registry: prometheus.NewRegistry()}
+	}
+
+	testCases := []struct {
+		name       string
+		maxEntries int
+		maxBytes   int64
+		expected   []string
+	}{
+		{name: "unlimited", expected: []string{"a", "b", "c"}},
+		{name: "max entries", maxEntries: 2, expected: []string{"a", "c"}},
+		{name: "max bytes", maxBytes: 2 * cacheEntryOverhead, expected: []string{"a", "c"}},
+		{name: "entry larger than max bytes", maxBytes: 1, expected: []string{"c"}},
+	}
+
+	for _, c := range testCases {
+		cache := newMemoryCache(time.Minute, newRetentionPolicy(time.Minute), c.maxEntries, c.maxBytes, 0)
+
+		cache.add("a", result("a"))
+		cache.add("b", result("b"))
+		// mark "a" as recently used, so "b" is evicted first
+		cache.get("a")
+		cache.add("c", result("c"))
+
+		var got []string
+		for _, entry := range cache.list() {
+			got = append(got, entry.result.Target)
+		}
+
+		if strings.Join(got, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expected, got)
+		}
+
+		cache.stop()
+	}
+}
+
+func TestHistoryEviction(t *testing.T) {
+	cache := newMemoryCache(time.Minute, newRetentionPolicy(time.Minute), 2, 0, 3)
+	defer cache.stop()
+
+	result := func(target string) *exporter.Result {
+		return &exporter.Result{Target: target, Registry: prometheus.NewRegistry()}
+	}
+	tracked := func() (targets []string) {
+		for _, target := range []string{"a", "b", "c"} {
+			if cache.history(target) != nil {
+				targets = append(targets, target)
+			}
+		}
+		return targets
+	}
+
+	cache.add("a", result("a"))
+	cache.addHistory("a", exporter.Snapshot{Grade: "A"})
+	cache.add("b", result("b"))
+	cache.addHistory("b", exporter.Snapshot{Grade: "B"})
+
+	// the history of an expired entry is kept for the next assessment
+	cache.entries["b"].Value.(*cacheEntry).expiryTime = 0
+	cache.prune()
+	if got := tracked(); strings.Join(got, ",") != "a,b" {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "expired_entry", "a,b", got)
+	}
+
+	// and evicted first when the cache is full
+	cache.add("c", result("c"))
+	if got := tracked(); strings.Join(got, ",") != "a" {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "full_cache", "a", got)
+	}
+
+	cache.remove("a")
+	if got := tracked(); len(got) != 0 || cache.historyBytes != 0 || cache.expired.Len() != 0 {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "removed_entry", nil, got)
+	}
+}
+
+func TestCacheMetrics(t *testing.T) {
+	cache := newMemoryCache(time.Minute, newRetentionPolicy(time.Minute), 1, 0, 0)
+	defer cache.stop()
+
+	cache.add("a", &exporter.Result{Target: "a", Registry: prometheus.NewRegistry()})
+	cache.get("a")
+	cache.get("404")
+	cache.add("b", &exporter.Result{Target: "b", Registry: prometheus.NewRegistry()})
+	cache.remove("b")
+
+	expected := `
+# HELP ssllabs_exporter_cache_entries Number of assessment results in the cache
+# TYPE ssllabs_exporter_cache_entries gauge
+ssllabs_exporter_cache_entries 0
+# HELP ssllabs_exporter_cache_evictions_total Number of entries removed from the cache by reason
+# TYPE ssllabs_exporter_cache_evictions_total counter
+ssllabs_exporter_cache_evictions_total{reason="capacity"} 1
+ssllabs_exporter_cache_evictions_total{reason="expired"} 0
+ssllabs_exporter_cache_evictions_total{reason="removed"} 1
+# HELP ssllabs_exporter_cache_hits_total Number of probes served from the cache
+# TYPE ssllabs_exporter_cache_hits_total counter
+ssllabs_exporter_cache_hits_total 1
+# HELP ssllabs_exporter_cache_misses_total Number of probes not found in the cache
+# TYPE ssllabs_exporter_cache_misses_total counter
+ssllabs_exporter_cache_misses_total 1
+# HELP ssllabs_exporter_cache_size_bytes Estimated memory used by the cached assessment results and histories
+# TYPE ssllabs_exporter_cache_size_bytes gauge
+ssllabs_exporter_cache_size_bytes 0
+`
+
+	if err := testutil.CollectAndCompare(cache, strings.NewReader(expected)); err != nil {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "cache metrics", nil, err)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/rs/zerolog"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/exporter"
+	"github.com/anas-aso/ssllabs_exporter/internal/validation"
+)
+
+// exit codes of the check command
+const (
+	checkPassed = 0
+	checkFailed = 1
+)
+
+// how many targets are assessed at the same time by the check command
+const checkParallelism = 4
+
+// matches all the vulnerabilities in the check command options
+const anyVulnerability = "any"
+
+// checkOptions are the pass conditions of the check command
+type checkOptions struct {
+	// grade below which a target fails, no minimum if empty
+	minGrade string
+	// vulnerabilities failing a target, anyVulnerability matching all of them
+	vulnerabilities []string
+	// how long a target assessment can take
+	timeout time.Duration
+	// table or json
+	output string
+	// labels added to the target metrics, none if nil
+	labels func(target string) map[string]string
+}
+
+// checkResult is the check command report of a target
+type checkResult struct {
+	*exporter.Report
+	Vulnerabilities []string `json:"vulnerabilities"`
+	Passed          bool     `json:"passed"`
+	Reasons         []string `json:"reasons,omitempty"`
+}
+
+// validate the check command options
+func (o *checkOptions) validate() error {
+	if o.minGrade != "" && !exporter.KnownGrade(o.minGrade) {
+		return fmt.Errorf("unknown minimum grade %q", o.minGrade)
+	}
+
+	known := exporter.VulnerabilityNames()
+	for _, v := range o.vulnerabilities {
+		if v != anyVulnerability && !slices.Contains(known, v) {
+			return fmt.Errorf("unknown vulnerability %q, expected %q or one of %v", v, anyVulnerability, known)
+		}
+	}
+
+	return nil
+}
+
+// assess the targets, write their report and return the exit code of the check command.
+// A target fails if it can't be assessed, has a grade below the minimum one, one of the listed vulnerabilities
+// or violates a policy rule.
+func runCheck(ctx context.Context, logger log.Logger, w io.Writer, targets []string, opts checkOptions,
+	handle func(ctx context.Context, logger log.Logger, target string) *exporter.Result) int {
+	results := make([]checkResult, len(targets))
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, checkParallelism)
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			results[i] = checkTarget(ctx, logger, target, opts, handle)
+		}()
+	}
+	wg.Wait()
+
+	var err error
+	if opts.output == "json" {
+		err = writeCheckJSON(w, results)
+	} else {
+		err = writeCheckTable(w, results)
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to write the check report")
+		return checkFailed
+	}
+
+	for _, r := range results {
+		if !r.Passed {
+			return checkFailed
+		}
+	}
+
+	return checkPassed
+}
+
+// assess a single target and check it against the options
+func checkTarget(ctx context.Context, logger log.Logger, target string, opts checkOptions,
+	handle func(ctx context.Context, logger log.Logger, target string) *exporter.Result) checkResult {
+	normalized, err := validation.Target(target)
+	if err != nil {
+		result := exporter.Interrupted(target, time.Now(), err)
+		return checkResult{
+			Report:          exporter.NewReport(result),
+			Vulnerabilities: []string{},
+			Reasons:         []string{err.Error()},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	if opts.labels != nil {
+		ctx = exporter.WithLabels(ctx, opts.labels(normalized))
+	}
+
+	result := handle(ctx, logger, normalized)
+	check := checkResult{
+		Report:          exporter.NewReport(result),
+		Vulnerabilities: []string{},
+	}
+
+	if result.Err != nil {
+		check.Reasons = append(check.Reasons, "assessment failed: "+result.Err.Error())
+		return check
+	}
+
+	check.Vulnerabilities = exporter.NewSnapshot(result).Vulnerabilities
+
+	if opts.minGrade != "" && exporter.GradeBelow(check.Grade, opts.minGrade) {
+		grade := check.Grade
+		if grade == "" {
+			grade = "no grade"
+		}
+		check.Reasons = append(check.Reasons, fmt.Sprintf("%s is below %s", grade, opts.minGrade))
+	}
+
+	for _, v := range check.Vulnerabilities {
+		if slices.Contains(opts.vulnerabilities, anyVulnerability) || slices.Contains(opts.vulnerabilities, v) {
+			check.Reasons = append(check.Reasons, "vulnerable to "+v)
+		}
+	}
+
+	for _, p := range result.Policies {
+		if !p.Passed {
+			check.Reasons = append(check.Reasons, "violates the "+p.Rule+" policy")
+		}
+	}
+
+	check.Passed = len(check.Reasons) == 0
+
+	return check
+}
+
+func writeCheckJSON(w io.Writer, results []checkResult) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(results)
+}
+
+func writeCheckTable(w io.Writer, results []checkResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tGRADE\tVULNERABILITIES\tRESULT\tREASONS")
+
+	for _, r := range results {
+		status := "PASS"
+		if !r.Passed {
+			status = "FAIL"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Target, orDash(r.Grade), orDash(strings.Join(r.Vulnerabilities, ",")), status, orDash(strings.Join(r.Reasons, "; ")))
+	}
+
+	return tw.Flush()
+}
+
+// placeholder of the empty table cells
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
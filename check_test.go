@@ -0,0 +1,181 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	ssllabsApi "github.com/essentialkaos/sslscan/v13"
+	log "github.com/rs/zerolog"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/config"
+	"github.com/anas-aso/ssllabs_exporter/internal/exporter"
+	"github.com/anas-aso/ssllabs_exporter/internal/ssllabs"
+)
+
+// fake assessments of the check command targets
+func checkHandle(ctx context.Context, logger log.Logger, target string) *exporter.Result {
+	switch target {
+	case "vulnerable.example.com":
+		result := gradedResult(target, "A", time.Now())
+		result.Info.Endpoints[0].Details.Heartbleed = true
+		return result
+	case "weak.example.com":
+		return gradedResult(target, "B", time.Now())
+	case "unknown.example.com":
+		return exporter.NewResult(target, time.Now(), time.Second, &ssllabsApi.AnalyzeInfo{}, ssllabs.NewError(ssllabs.StatusDNSError, "Unable to resolve domain name"))
+	}
+
+	return gradedResult(target, "A+", time.Now())
+}
+
+func TestRunCheck(t *testing.T) {
+	var cases = []struct {
+		name             string
+		targets          []string
+		opts             checkOptions
+		expectedCode     int
+		expectedFailures []string
+	}{
+		{
+			name:         "no_conditions",
+			targets:      []string{"example.com", "weak.example.com", "vulnerable.example.com"},
+			expectedCode: checkPassed,
+		},
+		{
+			name:             "min_grade",
+			targets:          []string{"example.com", "weak.example.com", "vulnerable.example.com"},
+			opts:             checkOptions{minGrade: "A"},
+			expectedCode:     checkFailed,
+			expectedFailures: []string{"weak.example.com"},
+		},
+		{
+			name:             "listed_vulnerability",
+			targets:          []string{"example.com", "vulnerable.example.com"},
+			opts:             checkOptions{vulnerabilities: []string{"heartbleed"}},
+			expectedCode:     checkFailed,
+			expectedFailures: []string{"vulnerable.example.com"},
+		},
+		{
+			name:         "other_vulnerability",
+			targets:      []string{"vulnerable.example.com"},
+			opts:         checkOptions{vulnerabilities: []string{"robot"}},
+			expectedCode: checkPassed,
+		},
+		{
+			name:             "any_vulnerability",
+			targets:          []string{"vulnerable.example.com"},
+			opts:             checkOptions{vulnerabilities: []string{anyVulnerability}},
+			expectedCode:     checkFailed,
+			expectedFailures: []string{"vulnerable.example.com"},
+		},
+		{
+			name:             "failed_assessment",
+			targets:          []string{"example.com", "unknown.example.com"},
+			expectedCode:     checkFailed,
+			expectedFailures: []string{"unknown.example.com"},
+		},
+		{
+			name:             "invalid_target",
+			targets:          []string{"127.0.0.1"},
+			expectedCode:     checkFailed,
+			expectedFailures: []string{"127.0.0.1"},
+		},
+	}
+
+	for _, c := range cases {
+		c.opts.timeout = time.Minute
+		c.opts.output = "json"
+
+		var output bytes.Buffer
+		code := runCheck(context.Background(), log.Nop(), &output, c.targets, c.opts, checkHandle)
+		if code != c.expectedCode {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedCode, code)
+		}
+
+		var results []checkResult
+		if err := json.Unmarshal(output.Bytes(), &results); err != nil {
+			t.Fatal(err)
+		}
+
+		var failures []string
+		for _, r := range results {
+			if !r.Passed {
+				failures = append(failures, r.Target)
+			}
+		}
+		if strings.Join(failures, ",") != strings.Join(c.expectedFailures, ",") {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedFailures, failures)
+		}
+	}
+}
+
+func TestRunCheckPolicies(t *testing.T) {
+	exporter.SetPolicies([]config.PolicyRule{{Name: "graded", MinGrade: "A"}})
+	defer exporter.SetPolicies(nil)
+
+	var output bytes.Buffer
+	opts := checkOptions{timeout: time.Minute, output: "json"}
+	if code := runCheck(context.Background(), log.Nop(), &output, []string{"example.com", "weak.example.com"}, opts, checkHandle); code != checkFailed {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "policies_exit_code", checkFailed, code)
+	}
+
+	var results []checkResult
+	if err := json.Unmarshal(output.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"violates the graded policy"}
+	if !results[0].Passed || results[1].Passed || !slices.Equal(results[1].Reasons, expected) {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "policies_reasons", expected, results[1].Reasons)
+	}
+}
+
+func TestRunCheckTable(t *testing.T) {
+	var output bytes.Buffer
+	opts := checkOptions{minGrade: "A", timeout: time.Minute, output: "table"}
+	runCheck(context.Background(), log.Nop(), &output, []string{"example.com", "weak.example.com"}, opts, checkHandle)
+
+	for _, expected := range []string{"TARGET", "A+", "PASS", "weak.example.com", "B is below A", "FAIL"} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "table_report", expected, output.String())
+		}
+	}
+}
+
+func TestCheckOptionsValidate(t *testing.T) {
+	var cases = []struct {
+		name          string
+		opts          checkOptions
+		expectedError bool
+	}{
+		{name: "defaults", opts: checkOptions{}, expectedError: false},
+		{name: "valid", opts: checkOptions{minGrade: "A-", vulnerabilities: []string{"heartbleed", anyVulnerability}}, expectedError: false},
+		{name: "unknown_grade", opts: checkOptions{minGrade: "Z"}, expectedError: true},
+		{name: "unknown_vulnerability", opts: checkOptions{vulnerabilities: []string{"meltdown"}}, expectedError: true},
+	}
+
+	for _, c := range cases {
+		if err := c.opts.validate(); (err != nil) != c.expectedError {
+			t.Errorf("Test case : %v failed.\nExpected error : %v\nGot : %v\n", c.name, c.expectedError, err)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/rs/zerolog"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/exporter"
+	"github.com/anas-aso/ssllabs_exporter/internal/validation"
+)
+
+// grade label value of the assessments without a graded endpoint
+const gradeNone = "none"
+
+var (
+	gradeChanges = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ssllabs_grade_changes_total",
+			Help: "Number of grade changes between two consecutive assessments of the target",
+		},
+		[]string{"target", "from", "to"},
+	)
+	gradeLastChange = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ssllabs_grade_last_change_timestamp_seconds",
+			Help: "When the assessment changing the target grade was generated in Unix time",
+		},
+		[]string{"target"},
+	)
+	assessmentChanges = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ssllabs_assessment_changes_total",
+			Help: "Number of changes between two consecutive assessments of the target by category",
+		},
+		[]string{"target", "category"},
+	)
+)
+
+// record a finished assessment in the target history and count the changes
+// since the previous one. Failed assessments are not related to the target grade
+// and are not recorded.
+func (a *assessments) recordHistory(logger log.Logger, target string, result *exporter.Result) {
+	if result.Err != nil || result.Info == nil {
+		return
+	}
+
+	record := exporter.NewSnapshot(result)
+
+	if records := a.cache.history(target); len(records) > 0 {
+		previous := records[len(records)-1]
+
+		// the same SSLLabs cached results can be returned by multiple assessments
+		if !record.AssessmentTime.After(previous.AssessmentTime) {
+			return
+		}
+
+		changes := exporter.Diff(previous, record)
+		for _, change := range changes {
+			logger.Info().Str("target", target).Str("category", change.Category).Msg(change.String())
+			assessmentChanges.WithLabelValues(target, change.Category).Inc()
+		}
+		a.webhooks.notify(target, previous, record, changes)
+
+		if previous.Grade != record.Grade {
+			from, to := gradeLabel(previous.Grade), gradeLabel(record.Grade)
+			gradeChanges.WithLabelValues(target, from, to).Inc()
+			gradeLastChange.WithLabelValues(target).Set(float64(record.AssessmentTime.Unix()))
+		}
+	}
+
+	a.cache.addHistory(target, record)
+}
+
+// delete the metrics of a target whose history is removed
+func forgetHistory(target string) {
+	gradeChanges.DeletePartialMatch(prometheus.Labels{"target": target})
+	gradeLastChange.DeleteLabelValues(target)
+	assessmentChanges.DeletePartialMatch(prometheus.Labels{"target": target})
+}
+
+// label value of a grade
+func gradeLabel(grade string) string {
+	if grade == "" {
+		return gradeNone
+	}
+
+	return grade
+}
+
+// serve the history of a target from the oldest to the latest assessment.
+// This never triggers a new assessment.
+func historyHandler(w http.ResponseWriter, r *http.Request, logger log.Logger, resultsCache cache) {
+	target, err := validation.Target(r.PathValue("target"))
+	if err != nil {
+		logger.Error().Err(err).Msg("Invalid target")
+		jsonError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	records := resultsCache.history(target)
+	if records == nil {
+		records = []exporter.Snapshot{}
+	}
+
+	jsonResponse(w, http.StatusOK, records)
+}
+
+// diffResponse is the JSON API representation of the changes between the two latest assessments of a target
+type diffResponse struct {
+	Target                 string            `json:"target"`
+	PreviousAssessmentTime time.Time         `json:"previous_assessment_time"`
+	AssessmentTime         time.Time         `json:"assessment_time"`
+	Changes                []exporter.Change `json:"changes"`
+}
+
+// serve the changes between the two latest assessments of a target.
+// This never triggers a new assessment.
+func diffHandler(w http.ResponseWriter, r *http.Request, logger log.Logger, resultsCache cache) {
+	target, err := validation.Target(r.PathValue("target"))
+	if err != nil {
+		logger.Error().Err(err).Msg("Invalid target")
+		jsonError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	records := resultsCache.history(target)
+	if len(records) < 2 {
+		jsonError(w, http.StatusNotFound, &validation.Error{Target: target, Reason: reasonNotFound, Message: "no previous assessment of the target in the history"})
+		return
+	}
+
+	previous, current := records[len(records)-2], records[len(records)-1]
+	jsonResponse(w, http.StatusOK, diffResponse{
+		Target:                 target,
+		PreviousAssessmentTime: previous.AssessmentTime,
+		AssessmentTime:         current.AssessmentTime,
+		Changes:                exporter.Diff(previous, current),
+	})
+}
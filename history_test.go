@@ -0,0 +1,205 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	ssllabsApi "github.com/essentialkaos/sslscan/v13"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/rs/zerolog"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/exporter"
+)
+
+// successful assessment of the target with a single endpoint
+func gradedResult(target, grade string, testTime time.Time) *exporter.Result {
+	info := &ssllabsApi.AnalyzeInfo{
+		Host:     target,
+		Status:   ssllabsApi.STATUS_READY,
+		TestTime: testTime.UnixMilli(),
+		Endpoints: []*ssllabsApi.EndpointInfo{{
+			IPAddress: "192.0.2.1",
+			Grade:     grade,
+			Details: &ssllabsApi.EndpointDetails{
+				Protocols: []*ssllabsApi.Protocol{{Name: "TLS", Version: "1.3"}, {Name: "TLS", Version: "1.2"}},
+			},
+		}},
+		Certs: []*ssllabsApi.Cert{{SerialNumber: "01"}},
+	}
+
+	return exporter.NewResult(target, testTime, time.Second, info, nil)
+}
+
+func TestRecordHistory(t *testing.T) {
+	var cases = []struct {
+		name           string
+		cache          func(t *testing.T) cache
+		expectedLength int
+	}{
+		{
+			name: "memory",
+			cache: func(t *testing.T) cache {
+				c := newMemoryCache(time.Minute, newRetentionPolicy(time.Minute), 0, 0, 3)
+				t.Cleanup(c.stop)
+				return c
+			},
+			expectedLength: 3,
+		},
+		{
+			name: "redis",
+			cache: func(t *testing.T) cache {
+				c, _ := newTestRedisCache(t, time.Minute)
+				return c
+			},
+			expectedLength: 3,
+		},
+		{
+			name: "disabled",
+			cache: func(t *testing.T) cache {
+				c := newMemoryCache(time.Minute, newRetentionPolicy(time.Minute), 0, 0, 0)
+				t.Cleanup(c.stop)
+				return c
+			},
+			expectedLength: 0,
+		},
+	}
+
+	for _, c := range cases {
+		target := c.name + ".example.com"
+		resultsCache := c.cache(t)
+		running := newAssessments(context.Background(), log.Nop(), time.Minute, resultsCache, false)
+
+		start := time.Now().Add(-time.Hour).Truncate(time.Second)
+		grades := []string{"A", "A", "B", "A"}
+		for i, grade := range grades {
+			running.recordHistory(log.Nop(), target, gradedResult(target, grade, start.Add(time.Duration(i)*time.Minute)))
+		}
+		// results fetched again from the SSLLabs cache are recorded only once
+		running.recordHistory(log.Nop(), target, gradedResult(target, "A", start.Add(3*time.Minute)))
+
+		records := resultsCache.history(target)
+		if len(records) != c.expectedLength {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedLength, len(records))
+			continue
+		}
+		if c.expectedLength == 0 {
+			continue
+		}
+
+		if last := records[len(records)-1]; last.Grade != "A" || !last.AssessmentTime.Equal(start.Add(3*time.Minute)) {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, start.Add(3*time.Minute), last.AssessmentTime)
+		}
+
+		for _, change := range [][]string{{"A", "B"}, {"B", "A"}} {
+			if got := testutil.ToFloat64(gradeChanges.WithLabelValues(target, change[0], change[1])); got != 1 {
+				t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name+"_"+change[0]+"_to_"+change[1], 1, got)
+			}
+		}
+
+		if got := testutil.ToFloat64(gradeLastChange.WithLabelValues(target)); got != float64(start.Add(3*time.Minute).Unix()) {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name+"_last_change", start.Add(3*time.Minute).Unix(), got)
+		}
+
+		// the target and its endpoint grades changed twice
+		if got := testutil.ToFloat64(assessmentChanges.WithLabelValues(target, exporter.ChangeGrade)); got != 4 {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name+"_changes", 4, got)
+		}
+
+		// the history and the metrics of a removed target are deleted
+		resultsCache.remove(target)
+		if records := resultsCache.history(target); len(records) != 0 || gradeLastChange.DeleteLabelValues(target) || assessmentChanges.DeletePartialMatch(prometheus.Labels{"target": target}) != 0 {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name+"_removed", 0, len(records))
+		}
+	}
+}
+
+func TestRecordHistoryFailed(t *testing.T) {
+	resultsCache := newMemoryCache(time.Minute, newRetentionPolicy(time.Minute), 0, 0, 3)
+	defer resultsCache.stop()
+	running := newAssessments(context.Background(), log.Nop(), time.Minute, resultsCache, false)
+
+	running.recordHistory(log.Nop(), "example.com", exporter.Interrupted("example.com", time.Now(), context.DeadlineExceeded))
+
+	if records := resultsCache.history("example.com"); len(records) != 0 {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "failed_assessment", 0, records)
+	}
+}
+
+func TestDiffAPI(t *testing.T) {
+	resultsCache := newMemoryCache(time.Minute, newRetentionPolicy(time.Minute), 0, 0, 3)
+	defer resultsCache.stop()
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	resultsCache.addHistory("prometheus.io", exporter.NewSnapshot(gradedResult("prometheus.io", "A", start)))
+	resultsCache.addHistory("prometheus.io", exporter.NewSnapshot(gradedResult("prometheus.io", "B", start.Add(time.Minute))))
+	resultsCache.addHistory("grafana.com", exporter.NewSnapshot(gradedResult("grafana.com", "A", start)))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/results/{target}/diff", func(w http.ResponseWriter, r *http.Request) {
+		diffHandler(w, r, log.Nop(), resultsCache)
+	})
+
+	var cases = []struct {
+		name            string
+		path            string
+		expectedStatus  int
+		expectedChanges int
+	}{
+		{
+			name:            "changed_target",
+			path:            "/api/v1/results/prometheus.io/diff",
+			expectedStatus:  http.StatusOK,
+			expectedChanges: 2,
+		},
+		{
+			name:           "single_assessment",
+			path:           "/api/v1/results/grafana.com/diff",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "invalid_target",
+			path:           "/api/v1/results/127.0.0.1/diff",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", c.path, nil))
+
+		if w.Code != c.expectedStatus {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedStatus, w.Code)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+
+		var diff diffResponse
+		if err := json.NewDecoder(w.Body).Decode(&diff); err != nil {
+			t.Fatal(err)
+		}
+		if len(diff.Changes) != c.expectedChanges || !diff.PreviousAssessmentTime.Equal(start) {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedChanges, diff)
+		}
+	}
+}
@@ -0,0 +1,712 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/validation"
+)
+
+// Config is the exporter configuration file content
+type Config struct {
+	Probe ProbeConfig `yaml:"probe"`
+	Admin AdminConfig `yaml:"admin"`
+	Cache CacheConfig `yaml:"cache"`
+
+	Tracing  TracingConfig   `yaml:"tracing"`
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+	Policies []PolicyRule    `yaml:"policies"`
+
+	Scheduler SchedulerConfig `yaml:"scheduler"`
+	Discovery DiscoveryConfig `yaml:"discovery"`
+}
+
+// ProbeConfig restricts who can use the /probe endpoint and which targets can be assessed
+type ProbeConfig struct {
+	// targets matching Deny are rejected even if they match Allow
+	Allow TargetMatcher `yaml:"allow"`
+	Deny  TargetMatcher `yaml:"deny"`
+
+	AuthConfig `yaml:",inline"`
+}
+
+// AdminConfig credentials of the cache administration endpoints,
+// which are disabled if none is configured
+type AdminConfig struct {
+	AuthConfig `yaml:",inline"`
+}
+
+// CacheConfig overrides the cache command line options for specific targets
+type CacheConfig struct {
+	// the first rule matching a target is applied
+	Retention []RetentionRule `yaml:"retention"`
+}
+
+// RetentionRule retention durations of the matching targets results,
+// the unset ones default to the command line options
+type RetentionRule struct {
+	Targets TargetMatcher `yaml:"targets"`
+
+	// successful assessments with a grade
+	Success *time.Duration `yaml:"success"`
+	// failed assessments, 0 disables caching them
+	Failed *time.Duration `yaml:"failed"`
+	// successful assessments without a graded endpoint
+	Ungraded *time.Duration `yaml:"ungraded"`
+}
+
+// RetentionRule returns the first retention rule matching the target, nil if none does
+func (c *CacheConfig) RetentionRule(target string) *RetentionRule {
+	for i := range c.Retention {
+		if c.Retention[i].Targets.Match(target) {
+			return &c.Retention[i]
+		}
+	}
+
+	return nil
+}
+
+// TracingConfig exports OpenTelemetry traces, which is disabled if no endpoint is set
+type TracingConfig struct {
+	// OTLP/HTTP traces endpoint such as http://otel-collector:4318/v1/traces
+	Endpoint string `yaml:"endpoint"`
+	// HTTP headers sent to the endpoint, such as authentication ones
+	Headers map[string]string `yaml:"headers"`
+	// fraction of the probes traced if not already sampled by the caller, 1 by default
+	SamplingRatio *float64 `yaml:"sampling_ratio"`
+}
+
+// Enabled checks whether the traces are exported
+func (c *TracingConfig) Enabled() bool {
+	return c.Endpoint != ""
+}
+
+// SchedulerConfig controls the background assessments of the discovered targets
+type SchedulerConfig struct {
+	// maximum age of the discovered targets results, until they expire from the cache by default
+	Interval *time.Duration `yaml:"interval"`
+	// how many scheduled assessments can run at the same time, 4 by default
+	Concurrency *int `yaml:"concurrency"`
+	// assessment settings the discovered targets can refer to by name
+	Modules map[string]ModuleConfig `yaml:"modules"`
+}
+
+// ModuleConfig overrides the scheduler settings for the targets referring to it
+type ModuleConfig struct {
+	// maximum age of the targets results, the scheduler interval by default
+	Interval *time.Duration `yaml:"interval"`
+	// ignore the SSLLabs cached results
+	StartNew bool `yaml:"start_new"`
+}
+
+// DiscoveryConfig sources of the targets assessed in the background by the scheduler
+type DiscoveryConfig struct {
+	// targets listed in the configuration file
+	Static     []TargetGroup               `yaml:"static"`
+	Files      []FileDiscoveryConfig       `yaml:"files"`
+	Kubernetes []KubernetesDiscoveryConfig `yaml:"kubernetes"`
+}
+
+// Enabled checks whether any discovery source is configured
+func (c *DiscoveryConfig) Enabled() bool {
+	return len(c.Static) > 0 || len(c.Files) > 0 || len(c.Kubernetes) > 0
+}
+
+// StaticLabels returns the labels of the first static targets group listing the normalized target, nil if none does
+func (c *DiscoveryConfig) StaticLabels(target string) map[string]string {
+	for _, g := range c.Static {
+		for _, raw := range g.Targets {
+			// the targets are validated when the configuration is loaded
+			if host, _ := validation.Target(raw); host == target {
+				return g.Labels
+			}
+		}
+	}
+
+	return nil
+}
+
+// TargetGroup is a list of targets sharing the same labels and module, in the Prometheus file_sd format
+type TargetGroup struct {
+	Targets []string `yaml:"targets" json:"targets"`
+	// added to the metrics of the targets assessments
+	Labels map[string]string `yaml:"labels" json:"labels"`
+	// name of the scheduler module applying to the targets, the default settings if empty
+	Module string `yaml:"module" json:"module"`
+}
+
+// FileDiscoveryConfig reads the targets from files in the Prometheus file_sd format
+type FileDiscoveryConfig struct {
+	// paths of the YAML (.yml, .yaml) or JSON (.json) files, the last path element can be a shell pattern
+	Files []string `yaml:"files"`
+	// how often the files are re-read besides when they change, 5m by default
+	RefreshInterval *time.Duration `yaml:"refresh_interval"`
+}
+
+// Kubernetes resources the hosts can be discovered from
+const (
+	ResourceIngress   = "ingress"
+	ResourceHTTPRoute = "httproute"
+)
+
+// KubernetesDiscoveryConfig discovers the hosts of the annotated Ingresses and Gateway API HTTPRoutes
+type KubernetesDiscoveryConfig struct {
+	// path of the kubeconfig file, the in-cluster configuration is used if empty
+	Kubeconfig string `yaml:"kubeconfig"`
+	// namespaces the resources are discovered in, all of them if empty
+	Namespaces []string `yaml:"namespaces"`
+	// kinds of the discovered resources, ingress and httproute by default
+	Resources []string `yaml:"resources"`
+	// only the resources with this annotation set to "true" are discovered, ssllabs.io/enabled by default
+	Annotation string `yaml:"annotation"`
+	// how often the resources are listed, 5m by default
+	RefreshInterval *time.Duration `yaml:"refresh_interval"`
+}
+
+// webhook events
+const (
+	// the target grade dropped below the webhook minimum grade
+	EventGradeBelow = "grade_below"
+	// a new vulnerability was found on the target
+	EventVulnerability = "vulnerability"
+	// the leaf certificate served by the target changed
+	EventCertificate = "certificate"
+)
+
+// grades which can be used as the webhooks and policies minimum grade
+var grades = []string{"A+", "A", "A-", "B", "C", "D", "E", "F", "M", "T"}
+
+// WebhookConfig notifies an HTTP endpoint about the matching targets assessments changes
+type WebhookConfig struct {
+	// identifies the webhook in the logs and metrics, the URL host by default
+	Name string `yaml:"name"`
+	// the JSON payloads are POSTed to this URL
+	URL string `yaml:"url"`
+	// signs the payloads with HMAC-SHA256 if set
+	SecretFile string `yaml:"secret_file"`
+	// targets the webhook is notified about, all of them if empty
+	Targets TargetMatcher `yaml:"targets"`
+	// events the webhook is notified about, all of them if empty
+	Events []string `yaml:"events"`
+	// grade below which the grade_below event is sent, required by this event
+	MinGrade string `yaml:"min_grade"`
+	// how long a delivery attempt can take, 10s by default
+	Timeout *time.Duration `yaml:"timeout"`
+	// how many times a failed delivery is retried, 3 by default
+	MaxRetries *int `yaml:"max_retries"`
+
+	// loaded from SecretFile
+	Secret string `yaml:"-"`
+}
+
+// Notified checks whether the webhook is notified about the target event
+func (c *WebhookConfig) Notified(target, event string) bool {
+	if event == EventGradeBelow && c.MinGrade == "" {
+		return false
+	}
+
+	if !c.Targets.Empty() && !c.Targets.Match(target) {
+		return false
+	}
+
+	return len(c.Events) == 0 || slices.Contains(c.Events, event)
+}
+
+// TLS versions which can be used as a policy minimum version, mapped to their protocol ID
+var tlsVersions = map[string]int{"1.0": 0x0301, "1.1": 0x0302, "1.2": 0x0303, "1.3": 0x0304}
+
+// PolicyRule is a compliance rule evaluated against the successful assessments of the matching targets.
+// A rule is violated if any of its conditions isn't met.
+type PolicyRule struct {
+	// identifies the rule in the metrics and reports
+	Name string `yaml:"name"`
+	// targets the rule applies to, all of them if empty
+	Targets TargetMatcher `yaml:"targets"`
+
+	// lowest TLS version the endpoints can support such as 1.2, SSL isn't allowed if set
+	MinTLSVersion string `yaml:"min_tls_version"`
+	// minimum size in bits of the leaf certificates keys by key algorithm (RSA or EC),
+	// the algorithms not listed aren't allowed
+	KeySizes map[string]int `yaml:"key_sizes"`
+	// minimum max-age of the HSTS policy of the endpoints
+	MinHSTSMaxAge *time.Duration `yaml:"min_hsts_max_age"`
+	// the endpoints must staple the OCSP responses
+	OCSPStapling bool `yaml:"ocsp_stapling"`
+	// the endpoints must not support CBC cipher suites
+	NoCBC bool `yaml:"no_cbc"`
+	// lowest grade of the target
+	MinGrade string `yaml:"min_grade"`
+}
+
+// MinTLSProtocol returns the protocol ID of the minimum TLS version, 0 if not set
+func (r *PolicyRule) MinTLSProtocol() int {
+	return tlsVersions[r.MinTLSVersion]
+}
+
+// Applies checks whether the rule applies to the target
+func (r *PolicyRule) Applies(target string) bool {
+	return r.Targets.Empty() || r.Targets.Match(target)
+}
+
+// AuthConfig credentials required to use an endpoint
+type AuthConfig struct {
+	BearerTokenFile string           `yaml:"bearer_token_file"`
+	BasicAuth       *BasicAuthConfig `yaml:"basic_auth"`
+
+	// loaded from BearerTokenFile
+	BearerToken string `yaml:"-"`
+}
+
+// BasicAuthConfig basic authentication credentials
+type BasicAuthConfig struct {
+	Username     string `yaml:"username"`
+	PasswordFile string `yaml:"password_file"`
+
+	// loaded from PasswordFile
+	Password string `yaml:"-"`
+}
+
+// TargetMatcher matches targets host names
+type TargetMatcher struct {
+	// domain names matching themselves and all their sub-domains
+	Suffixes []string `yaml:"suffixes"`
+	// shell patterns where * matches any sequence of characters
+	Globs []string `yaml:"globs"`
+	// regular expressions matching the whole host name
+	Regexes []Regexp `yaml:"regexes"`
+}
+
+// Regexp is a regular expression anchored at both ends
+type Regexp struct {
+	*regexp.Regexp
+}
+
+// UnmarshalYAML compiles the regular expression
+func (r *Regexp) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	re, err := regexp.Compile("^(?:" + s + ")$")
+	if err != nil {
+		return err
+	}
+
+	r.Regexp = re
+
+	return nil
+}
+
+// Empty checks whether the matcher has no patterns
+func (m *TargetMatcher) Empty() bool {
+	return len(m.Suffixes) == 0 && len(m.Globs) == 0 && len(m.Regexes) == 0
+}
+
+// Match checks whether the host name matches any of the patterns
+func (m *TargetMatcher) Match(host string) bool {
+	for _, suffix := range m.Suffixes {
+		suffix = strings.Trim(strings.ToLower(suffix), ".")
+		if host == suffix || strings.HasSuffix(host, "."+suffix) {
+			return true
+		}
+	}
+
+	for _, glob := range m.Globs {
+		// patterns are validated when the configuration is loaded
+		if ok, _ := path.Match(strings.ToLower(glob), host); ok {
+			return true
+		}
+	}
+
+	for _, re := range m.Regexes {
+		if re.MatchString(host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Allowed checks whether the target can be assessed
+func (c *ProbeConfig) Allowed(target string) bool {
+	if c.Deny.Match(target) {
+		return false
+	}
+
+	return c.Allow.Empty() || c.Allow.Match(target)
+}
+
+// Load reads and validates the configuration file.
+// An empty path returns the default configuration.
+func Load(file string) (*Config, error) {
+	cfg := &Config{}
+	if file == "" {
+		return cfg, nil
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", file, err)
+	}
+
+	if err := cfg.Probe.load(); err != nil {
+		return nil, fmt.Errorf("loading %s: %w", file, err)
+	}
+
+	if err := cfg.Admin.load(); err != nil {
+		return nil, fmt.Errorf("loading %s: %w", file, err)
+	}
+
+	if err := cfg.Cache.load(); err != nil {
+		return nil, fmt.Errorf("loading %s: %w", file, err)
+	}
+
+	if err := cfg.Tracing.load(); err != nil {
+		return nil, fmt.Errorf("loading %s: %w", file, err)
+	}
+
+	if err := loadPolicies(cfg.Policies); err != nil {
+		return nil, fmt.Errorf("loading %s: %w", file, err)
+	}
+
+	if err := cfg.Scheduler.load(); err != nil {
+		return nil, fmt.Errorf("loading %s: %w", file, err)
+	}
+
+	for i, g := range cfg.Discovery.Static {
+		if err := g.validate(); err != nil {
+			return nil, fmt.Errorf("loading %s: static targets group %d: %w", file, i, err)
+		}
+	}
+
+	for i := range cfg.Discovery.Files {
+		if err := cfg.Discovery.Files[i].load(); err != nil {
+			return nil, fmt.Errorf("loading %s: file discovery %d: %w", file, i, err)
+		}
+	}
+
+	for i := range cfg.Discovery.Kubernetes {
+		if err := cfg.Discovery.Kubernetes[i].load(); err != nil {
+			return nil, fmt.Errorf("loading %s: kubernetes discovery %d: %w", file, i, err)
+		}
+	}
+
+	for i := range cfg.Webhooks {
+		if err := cfg.Webhooks[i].load(); err != nil {
+			return nil, fmt.Errorf("loading %s: webhook %d: %w", file, i, err)
+		}
+	}
+
+	return cfg, nil
+}
+
+// Enabled checks whether credentials are configured
+func (c *AuthConfig) Enabled() bool {
+	return c.BearerToken != "" || c.BasicAuth != nil
+}
+
+// validate the probe configuration and load the referenced secrets
+func (c *ProbeConfig) load() error {
+	for _, m := range []TargetMatcher{c.Allow, c.Deny} {
+		if err := m.validate(); err != nil {
+			return err
+		}
+	}
+
+	return c.AuthConfig.load()
+}
+
+// validate the cache configuration
+func (c *CacheConfig) load() error {
+	for i, rule := range c.Retention {
+		if rule.Targets.Empty() {
+			return fmt.Errorf("cache retention rule %d has no targets", i)
+		}
+
+		if err := rule.Targets.validate(); err != nil {
+			return err
+		}
+
+		for _, d := range []*time.Duration{rule.Success, rule.Failed, rule.Ungraded} {
+			if d != nil && *d < 0 {
+				return fmt.Errorf("cache retention rule %d has a negative duration", i)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validate the tracing configuration
+func (c *TracingConfig) load() error {
+	if c.Endpoint != "" {
+		u, err := url.Parse(c.Endpoint)
+		if err != nil {
+			return fmt.Errorf("invalid tracing endpoint: %w", err)
+		}
+
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid tracing endpoint %q: an http or https URL is expected", c.Endpoint)
+		}
+	}
+
+	if c.SamplingRatio != nil && (*c.SamplingRatio < 0 || *c.SamplingRatio > 1) {
+		return fmt.Errorf("tracing sampling_ratio must be between 0 and 1")
+	}
+
+	return nil
+}
+
+// validate the webhook configuration and load the referenced secret
+func (c *WebhookConfig) load() error {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url %q: an http or https URL is expected", c.URL)
+	}
+
+	if c.Name == "" {
+		c.Name = u.Host
+	}
+
+	if err := c.Targets.validate(); err != nil {
+		return err
+	}
+
+	for _, event := range c.Events {
+		if event != EventGradeBelow && event != EventVulnerability && event != EventCertificate {
+			return fmt.Errorf("unknown event %q", event)
+		}
+	}
+
+	if c.MinGrade != "" && !slices.Contains(grades, c.MinGrade) {
+		return fmt.Errorf("unknown min_grade %q", c.MinGrade)
+	}
+
+	if c.MinGrade == "" && slices.Contains(c.Events, EventGradeBelow) {
+		return fmt.Errorf("the %s event requires a min_grade", EventGradeBelow)
+	}
+
+	if c.Timeout != nil && *c.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+
+	if c.MaxRetries != nil && *c.MaxRetries < 0 {
+		return fmt.Errorf("max_retries must not be negative")
+	}
+
+	if c.SecretFile != "" {
+		secret, err := readSecret(c.SecretFile)
+		if err != nil {
+			return err
+		}
+		c.Secret = secret
+	}
+
+	return nil
+}
+
+// validate the scheduler configuration
+func (c *SchedulerConfig) load() error {
+	if c.Interval != nil && *c.Interval <= 0 {
+		return fmt.Errorf("scheduler interval must be positive")
+	}
+
+	if c.Concurrency != nil && *c.Concurrency <= 0 {
+		return fmt.Errorf("scheduler concurrency must be positive")
+	}
+
+	for name, module := range c.Modules {
+		if name == "" {
+			return fmt.Errorf("scheduler modules must have a name")
+		}
+
+		if module.Interval != nil && *module.Interval <= 0 {
+			return fmt.Errorf("scheduler module %q interval must be positive", name)
+		}
+	}
+
+	return nil
+}
+
+// validate the targets and labels of the group
+func (g *TargetGroup) validate() error {
+	for _, raw := range g.Targets {
+		if _, err := validation.Target(raw); err != nil {
+			return err
+		}
+	}
+
+	return validation.Labels(g.Labels)
+}
+
+// validate the file discovery configuration
+func (c *FileDiscoveryConfig) load() error {
+	if len(c.Files) == 0 {
+		return fmt.Errorf("no files configured")
+	}
+
+	for _, f := range c.Files {
+		if ext := filepath.Ext(f); ext != ".yml" && ext != ".yaml" && ext != ".json" {
+			return fmt.Errorf("file %q must have a .yml, .yaml or .json extension", f)
+		}
+
+		if _, err := filepath.Match(filepath.Base(f), ""); err != nil {
+			return fmt.Errorf("invalid file pattern %q: %w", f, err)
+		}
+	}
+
+	if c.RefreshInterval != nil && *c.RefreshInterval <= 0 {
+		return fmt.Errorf("refresh_interval must be positive")
+	}
+
+	return nil
+}
+
+// validate the Kubernetes discovery configuration
+func (c *KubernetesDiscoveryConfig) load() error {
+	for _, r := range c.Resources {
+		if r != ResourceIngress && r != ResourceHTTPRoute {
+			return fmt.Errorf("unknown resource %q, %s or %s is expected", r, ResourceIngress, ResourceHTTPRoute)
+		}
+	}
+
+	if c.RefreshInterval != nil && *c.RefreshInterval <= 0 {
+		return fmt.Errorf("refresh_interval must be positive")
+	}
+
+	return nil
+}
+
+// validate the policy rules
+func loadPolicies(rules []PolicyRule) error {
+	names := make(map[string]bool)
+
+	for i, rule := range rules {
+		if rule.Name == "" {
+			return fmt.Errorf("policy rule %d has no name", i)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("duplicate policy rule %q", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if err := rule.Targets.validate(); err != nil {
+			return err
+		}
+
+		if rule.MinTLSVersion == "" && len(rule.KeySizes) == 0 && rule.MinHSTSMaxAge == nil &&
+			!rule.OCSPStapling && !rule.NoCBC && rule.MinGrade == "" {
+			return fmt.Errorf("policy rule %q has no condition", rule.Name)
+		}
+
+		if _, ok := tlsVersions[rule.MinTLSVersion]; rule.MinTLSVersion != "" && !ok {
+			return fmt.Errorf("policy rule %q has an unknown min_tls_version %q", rule.Name, rule.MinTLSVersion)
+		}
+
+		for alg, size := range rule.KeySizes {
+			if alg != "RSA" && alg != "EC" {
+				return fmt.Errorf("policy rule %q has an unknown key algorithm %q, RSA or EC is expected", rule.Name, alg)
+			}
+			if size <= 0 {
+				return fmt.Errorf("policy rule %q has a non positive %s key size", rule.Name, alg)
+			}
+		}
+
+		if rule.MinHSTSMaxAge != nil && *rule.MinHSTSMaxAge < 0 {
+			return fmt.Errorf("policy rule %q has a negative min_hsts_max_age", rule.Name)
+		}
+
+		if rule.MinGrade != "" && !slices.Contains(grades, rule.MinGrade) {
+			return fmt.Errorf("policy rule %q has an unknown min_grade %q", rule.Name, rule.MinGrade)
+		}
+	}
+
+	return nil
+}
+
+// validate the matcher patterns
+func (m *TargetMatcher) validate() error {
+	for _, glob := range m.Globs {
+		if _, err := path.Match(glob, ""); err != nil {
+			return fmt.Errorf("invalid glob %q: %w", glob, err)
+		}
+	}
+
+	return nil
+}
+
+// validate the credentials configuration and load the referenced secrets
+func (c *AuthConfig) load() error {
+	if c.BearerTokenFile != "" && c.BasicAuth != nil {
+		return fmt.Errorf("at most one of bearer_token_file and basic_auth can be configured")
+	}
+
+	if c.BearerTokenFile != "" {
+		token, err := readSecret(c.BearerTokenFile)
+		if err != nil {
+			return err
+		}
+		c.BearerToken = token
+	}
+
+	if c.BasicAuth != nil {
+		if c.BasicAuth.Username == "" || c.BasicAuth.PasswordFile == "" {
+			return fmt.Errorf("basic_auth requires both username and password_file")
+		}
+
+		password, err := readSecret(c.BasicAuth.PasswordFile)
+		if err != nil {
+			return err
+		}
+		c.BasicAuth.Password = password
+	}
+
+	return nil
+}
+
+// read a secret from a file ignoring the surrounding white spaces
+func readSecret(file string) (string, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	secret := strings.TrimSpace(string(content))
+	if secret == "" {
+		return "", fmt.Errorf("empty secret in %s", file)
+	}
+
+	return secret, nil
+}
@@ -0,0 +1,441 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// write the content to a temporary file and return its path
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func TestLoad(t *testing.T) {
+	tokenFile := writeFile(t, "token", "secret\n")
+
+	var cases = []struct {
+		name          string
+		content       string
+		expectedError bool
+	}{
+		{
+			name: "valid_config",
+			content: `
+probe:
+  allow:
+    suffixes: [example.com]
+    globs: ["*.example.org"]
+    regexes: ["api[0-9]+\\.example\\.net"]
+  deny:
+    suffixes: [internal.example.com]
+  bearer_token_file: ` + tokenFile,
+			expectedError: false,
+		},
+		{
+			name:          "admin_credentials",
+			content:       "admin:\n  bearer_token_file: " + tokenFile + "\n",
+			expectedError: false,
+		},
+		{
+			name:          "unknown_field",
+			content:       "probe:\n  unknown: true\n",
+			expectedError: true,
+		},
+		{
+			name:          "invalid_regex",
+			content:       "probe:\n  deny:\n    regexes: [\"(\"]\n",
+			expectedError: true,
+		},
+		{
+			name:          "invalid_glob",
+			content:       "probe:\n  deny:\n    globs: [\"[\"]\n",
+			expectedError: true,
+		},
+		{
+			name:          "missing_secret_file",
+			content:       "probe:\n  bearer_token_file: /non/existing/file\n",
+			expectedError: true,
+		},
+		{
+			name:          "retention_rule_without_targets",
+			content:       "cache:\n  retention:\n    - failed: 5m\n",
+			expectedError: true,
+		},
+		{
+			name:          "negative_retention",
+			content:       "cache:\n  retention:\n    - targets:\n        suffixes: [example.com]\n      failed: -5m\n",
+			expectedError: true,
+		},
+		{
+			name:          "invalid_tracing_endpoint",
+			content:       "tracing:\n  endpoint: otel-collector:4318\n",
+			expectedError: true,
+		},
+		{
+			name:          "invalid_sampling_ratio",
+			content:       "tracing:\n  endpoint: http://otel-collector:4318\n  sampling_ratio: 2\n",
+			expectedError: true,
+		},
+		{
+			name:          "invalid_webhook_url",
+			content:       "webhooks:\n  - url: hooks.example.com\n",
+			expectedError: true,
+		},
+		{
+			name:          "unknown_webhook_event",
+			content:       "webhooks:\n  - url: https://hooks.example.com\n    events: [expired]\n",
+			expectedError: true,
+		},
+		{
+			name:          "webhook_grade_event_without_min_grade",
+			content:       "webhooks:\n  - url: https://hooks.example.com\n    events: [grade_below]\n",
+			expectedError: true,
+		},
+		{
+			name:          "unknown_webhook_min_grade",
+			content:       "webhooks:\n  - url: https://hooks.example.com\n    min_grade: Z\n",
+			expectedError: true,
+		},
+		{
+			name:          "policy_without_name",
+			content:       "policies:\n  - min_tls_version: \"1.2\"\n",
+			expectedError: true,
+		},
+		{
+			name:          "duplicated_policy_name",
+			content:       "policies:\n  - name: tls\n    min_tls_version: \"1.2\"\n  - name: tls\n    no_cbc: true\n",
+			expectedError: true,
+		},
+		{
+			name:          "policy_without_condition",
+			content:       "policies:\n  - name: empty\n",
+			expectedError: true,
+		},
+		{
+			name:          "unknown_policy_tls_version",
+			content:       "policies:\n  - name: tls\n    min_tls_version: \"1.4\"\n",
+			expectedError: true,
+		},
+		{
+			name:          "unknown_policy_key_algorithm",
+			content:       "policies:\n  - name: keys\n    key_sizes:\n      DSA: 2048\n",
+			expectedError: true,
+		},
+		{
+			name:          "invalid_policy_key_size",
+			content:       "policies:\n  - name: keys\n    key_sizes:\n      RSA: 0\n",
+			expectedError: true,
+		},
+		{
+			name:          "negative_policy_hsts_max_age",
+			content:       "policies:\n  - name: hsts\n    min_hsts_max_age: -1h\n",
+			expectedError: true,
+		},
+		{
+			name:          "unknown_policy_min_grade",
+			content:       "policies:\n  - name: grade\n    min_grade: Z\n",
+			expectedError: true,
+		},
+		{
+			name:          "non_positive_scheduler_interval",
+			content:       "scheduler:\n  interval: 0s\n",
+			expectedError: true,
+		},
+		{
+			name:          "non_positive_scheduler_concurrency",
+			content:       "scheduler:\n  concurrency: 0\n",
+			expectedError: true,
+		},
+		{
+			name:          "non_positive_module_interval",
+			content:       "scheduler:\n  modules:\n    daily:\n      interval: -1h\n",
+			expectedError: true,
+		},
+		{
+			name:          "file_discovery_without_files",
+			content:       "discovery:\n  files:\n    - refresh_interval: 1m\n",
+			expectedError: true,
+		},
+		{
+			name:          "unknown_file_discovery_extension",
+			content:       "discovery:\n  files:\n    - files: [/etc/targets.txt]\n",
+			expectedError: true,
+		},
+		{
+			name:          "invalid_file_discovery_pattern",
+			content:       "discovery:\n  files:\n    - files: ['/etc/[.yml']\n",
+			expectedError: true,
+		},
+		{
+			name:          "unknown_kubernetes_resource",
+			content:       "discovery:\n  kubernetes:\n    - resources: [service]\n",
+			expectedError: true,
+		},
+		{
+			name:          "non_positive_kubernetes_refresh_interval",
+			content:       "discovery:\n  kubernetes:\n    - refresh_interval: 0s\n",
+			expectedError: true,
+		},
+		{
+			name:          "invalid_static_target",
+			content:       "discovery:\n  static:\n    - targets: ['http://127.0.0.1']\n",
+			expectedError: true,
+		},
+		{
+			name:          "invalid_static_label",
+			content:       "discovery:\n  static:\n    - targets: [example.com]\n      labels:\n        team.name: web\n",
+			expectedError: true,
+		},
+		{
+			name:          "reserved_static_label",
+			content:       "discovery:\n  static:\n    - targets: [example.com]\n      labels:\n        grade: A\n",
+			expectedError: true,
+		},
+		{
+			name:          "multiple_authentication_methods",
+			content:       "probe:\n  bearer_token_file: " + tokenFile + "\n  basic_auth:\n    username: user\n    password_file: " + tokenFile + "\n",
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		cfg, err := Load(writeFile(t, "config.yml", c.content))
+		if (err != nil) != c.expectedError {
+			t.Errorf("Test case : %v failed.\nExpected error : %v\nGot : %v\n", c.name, c.expectedError, err)
+		}
+
+		if err == nil && cfg.Probe.BearerToken != "secret" && cfg.Admin.BearerToken != "secret" {
+			t.Errorf("Test case : %v failed, bearer token file not loaded", c.name)
+		}
+	}
+
+	if cfg, err := Load(""); err != nil || cfg.Probe.Enabled() || cfg.Admin.Enabled() {
+		t.Errorf("Default configuration should not require authentication")
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	cfg, err := Load(writeFile(t, "config.yml", `
+probe:
+  allow:
+    suffixes: [example.com]
+    globs: ["www*.example.org"]
+    regexes: ["api[0-9]+\\.example\\.net"]
+  deny:
+    suffixes: [internal.example.com]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cases = []struct {
+		target         string
+		expectedResult bool
+	}{
+		{target: "example.com", expectedResult: true},
+		{target: "www.example.com", expectedResult: true},
+		{target: "notexample.com", expectedResult: false},
+		{target: "internal.example.com", expectedResult: false},
+		{target: "host.internal.example.com", expectedResult: false},
+		{target: "www2.example.org", expectedResult: true},
+		{target: "example.org", expectedResult: false},
+		{target: "api1.example.net", expectedResult: true},
+		{target: "api1.example.net.evil.com", expectedResult: false},
+	}
+
+	for _, c := range cases {
+		result := cfg.Probe.Allowed(c.target)
+		if result != c.expectedResult {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.target, c.expectedResult, result)
+		}
+	}
+}
+
+func TestStaticLabels(t *testing.T) {
+	cfg, err := Load(writeFile(t, "config.yml", `
+discovery:
+  static:
+    - targets: [example.com, "https://www.example.com/"]
+      labels:
+        team: web
+    - targets: [example.org]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cases = []struct {
+		target         string
+		expectedResult map[string]string
+	}{
+		{target: "example.com", expectedResult: map[string]string{"team": "web"}},
+		{target: "www.example.com", expectedResult: map[string]string{"team": "web"}},
+		{target: "example.org", expectedResult: nil},
+		{target: "example.net", expectedResult: nil},
+	}
+
+	for _, c := range cases {
+		result := cfg.Discovery.StaticLabels(c.target)
+		if !maps.Equal(result, c.expectedResult) {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.target, c.expectedResult, result)
+		}
+	}
+}
+
+func TestRetentionRule(t *testing.T) {
+	cfg, err := Load(writeFile(t, "config.yml", `
+cache:
+  retention:
+    - targets:
+        suffixes: [staging.example.com]
+      failed: 0s
+    - targets:
+        suffixes: [example.com]
+      success: 6h
+      failed: 5m
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cases = []struct {
+		target          string
+		expectedSuccess *time.Duration
+		expectedFailed  *time.Duration
+	}{
+		{target: "www.staging.example.com", expectedSuccess: nil, expectedFailed: durationPtr(0)},
+		{target: "www.example.com", expectedSuccess: durationPtr(6 * time.Hour), expectedFailed: durationPtr(5 * time.Minute)},
+	}
+
+	for _, c := range cases {
+		rule := cfg.Cache.RetentionRule(c.target)
+		if rule == nil {
+			t.Errorf("Test case : %v failed, no matching rule", c.target)
+			continue
+		}
+
+		if !durationEqual(rule.Success, c.expectedSuccess) || !durationEqual(rule.Failed, c.expectedFailed) {
+			t.Errorf("Test case : %v failed.\nExpected : %v %v\nGot : %v %v\n", c.target, c.expectedSuccess, c.expectedFailed, rule.Success, rule.Failed)
+		}
+	}
+
+	if rule := cfg.Cache.RetentionRule("example.org"); rule != nil {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "example.org", nil, rule)
+	}
+}
+
+func durationPtr(d time.Duration) *time.Duration {
+	return &d
+}
+
+func durationEqual(a, b *time.Duration) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return *a == *b
+}
+
+func TestWebhookNotified(t *testing.T) {
+	cfg, err := Load(writeFile(t, "config.yml", `
+webhooks:
+  - url: https://hooks.example.com/ssllabs
+    targets:
+      suffixes: [example.com]
+    events: [grade_below, certificate]
+    min_grade: A
+  - url: https://hooks.example.org/ssllabs
+    name: all
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cases = []struct {
+		name           string
+		webhook        int
+		target         string
+		event          string
+		expectedResult bool
+	}{
+		{name: "matching_target_and_event", webhook: 0, target: "www.example.com", event: EventGradeBelow, expectedResult: true},
+		{name: "other_target", webhook: 0, target: "example.org", event: EventGradeBelow, expectedResult: false},
+		{name: "other_event", webhook: 0, target: "example.com", event: EventVulnerability, expectedResult: false},
+		{name: "all_events", webhook: 1, target: "example.org", event: EventVulnerability, expectedResult: true},
+		{name: "grade_without_min_grade", webhook: 1, target: "example.org", event: EventGradeBelow, expectedResult: false},
+	}
+
+	for _, c := range cases {
+		if got := cfg.Webhooks[c.webhook].Notified(c.target, c.event); got != c.expectedResult {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedResult, got)
+		}
+	}
+
+	if cfg.Webhooks[0].Name != "hooks.example.com" || cfg.Webhooks[1].Name != "all" {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "webhook_names", []string{"hooks.example.com", "all"}, []string{cfg.Webhooks[0].Name, cfg.Webhooks[1].Name})
+	}
+}
+
+func TestPolicyRule(t *testing.T) {
+	cfg, err := Load(writeFile(t, "config.yml", `
+policies:
+  - name: pci
+    targets:
+      suffixes: [example.com]
+    min_tls_version: "1.2"
+  - name: hsts
+    min_hsts_max_age: 4380h
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cases = []struct {
+		name           string
+		rule           int
+		target         string
+		expectedResult bool
+	}{
+		{name: "matching_target", rule: 0, target: "www.example.com", expectedResult: true},
+		{name: "other_target", rule: 0, target: "example.org", expectedResult: false},
+		{name: "all_targets", rule: 1, target: "example.org", expectedResult: true},
+	}
+
+	for _, c := range cases {
+		if got := cfg.Policies[c.rule].Applies(c.target); got != c.expectedResult {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedResult, got)
+		}
+	}
+
+	if got := cfg.Policies[0].MinTLSProtocol(); got != 0x0303 {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "min_tls_protocol", 0x0303, got)
+	}
+
+	if got := cfg.Policies[1].MinTLSProtocol(); got != 0 {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "no_min_tls_protocol", 0, got)
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/rs/zerolog"
+	"gopkg.in/yaml.v2"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/config"
+)
+
+// how often the files are re-read if not configured
+const defaultRefreshInterval = 5 * time.Minute
+
+// File discovers the targets listed in YAML or JSON files in the Prometheus file_sd format.
+// The files are re-read when they change and at each refresh interval.
+type File struct {
+	name     string
+	patterns []string
+	refresh  time.Duration
+	logger   log.Logger
+
+	// latest valid targets of each file, kept while the file content is invalid
+	files map[string][]Target
+	// latest targets sent to the updater
+	targets []Target
+	// directories which could not be watched, logged once
+	unwatched map[string]bool
+}
+
+// NewFile creates a file discovery source identified by name
+func NewFile(name string, cfg *config.FileDiscoveryConfig, logger log.Logger) *File {
+	refresh := defaultRefreshInterval
+	if cfg.RefreshInterval != nil {
+		refresh = *cfg.RefreshInterval
+	}
+
+	return &File{
+		name:      name,
+		patterns:  cfg.Files,
+		refresh:   refresh,
+		logger:    logger.With().Str("source", name).Logger(),
+		files:     make(map[string][]Target),
+		unwatched: make(map[string]bool),
+	}
+}
+
+// Run sends the discovered targets to the updater until the context is done
+func (d *File) Run(ctx context.Context, update Updater) {
+	// the directories are watched instead of the files to notice the
+	// created files and the atomic replacements (e.g Kubernetes ConfigMaps)
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		d.logger.Error().Err(err).Msg("failed to watch the targets files, only refreshing them periodically")
+	} else {
+		defer watcher.Close()
+		d.watch(watcher)
+	}
+
+	d.refreshTargets(update)
+
+	ticker := time.NewTicker(d.refresh)
+	defer ticker.Stop()
+
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	if watcher != nil {
+		events, errs = watcher.Events, watcher.Errors
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if watcher != nil {
+				d.watch(watcher)
+			}
+		case event := <-events:
+			d.logger.Debug().Str("file", event.Name).Str("operation", event.Op.String()).Msg("targets files changed")
+		case err := <-errs:
+			d.logger.Error().Err(err).Msg("failed to watch the targets files")
+			continue
+		}
+
+		d.refreshTargets(update)
+	}
+}
+
+// watch the directories of the files patterns which are not watched yet.
+// The missing directories are watched once created, and the removed ones
+// once created again, at the next refresh.
+func (d *File) watch(watcher *fsnotify.Watcher) {
+	watched := watcher.WatchList()
+
+	for _, dir := range d.dirs() {
+		if slices.Contains(watched, dir) {
+			continue
+		}
+
+		if err := watcher.Add(dir); err != nil {
+			if !d.unwatched[dir] {
+				d.logger.Error().Err(err).Str("directory", dir).Msg("failed to watch the targets files directory, retrying at each refresh")
+			}
+			d.unwatched[dir] = true
+			continue
+		}
+
+		if d.unwatched[dir] {
+			d.logger.Info().Str("directory", dir).Msg("watching the targets files directory")
+		}
+		delete(d.unwatched, dir)
+	}
+}
+
+// directories of the files patterns
+func (d *File) dirs() []string {
+	var dirs []string
+	for _, p := range d.patterns {
+		if dir := filepath.Dir(p); !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
+}
+
+// read the files and send their targets to the updater if they changed
+func (d *File) refreshTargets(update Updater) {
+	files := make(map[string][]Target)
+
+	for _, p := range d.patterns {
+		// the patterns are validated when the configuration is loaded
+		matches, _ := filepath.Glob(p)
+
+		for _, f := range matches {
+			targets, err := readFile(f)
+			if err != nil {
+				d.logger.Error().Err(err).Str("file", f).Msg("failed to read the targets file, keeping its previous targets")
+				failures.WithLabelValues(d.name).Inc()
+				targets = d.files[f]
+			}
+
+			files[f] = targets
+		}
+	}
+
+	d.files = files
+
+	var targets []Target
+	for _, f := range sortedFiles(files) {
+		targets = append(targets, files[f]...)
+	}
+
+	if d.targets != nil && reflect.DeepEqual(targets, d.targets) {
+		return
+	}
+
+	// an empty list is still sent once so that the updater knows the source is ready
+	if targets == nil {
+		targets = []Target{}
+	}
+	d.targets = targets
+
+	d.logger.Info().Int("targets", len(targets)).Int("files", len(files)).Msg("discovered targets updated")
+	update(d.name, targets)
+}
+
+// read the targets of a YAML or JSON file
+func readFile(file string) ([]Target, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []Group
+	switch filepath.Ext(file) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&groups)
+	default:
+		err = yaml.UnmarshalStrict(content, &groups)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", file, err)
+	}
+
+	targets, err := Targets(groups)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %w", file, err)
+	}
+
+	return targets, nil
+}
+
+func sortedFiles(files map[string][]Target) []string {
+	names := make([]string, 0, len(files))
+	for f := range files {
+		names = append(names, f)
+	}
+	slices.Sort(names)
+
+	return names
+}
@@ -0,0 +1,203 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/rs/zerolog"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/config"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func TestReadFile(t *testing.T) {
+	dir := t.TempDir()
+
+	var cases = []struct {
+		name            string
+		file            string
+		content         string
+		expectedTargets []Target
+		expectedError   bool
+	}{
+		{
+			name:    "yaml",
+			file:    "targets.yml",
+			content: "- targets: [Example.com, 'https://www.example.com/path']\n  labels:\n    team: web\n  module: daily\n- targets: [example.org]\n",
+			expectedTargets: []Target{
+				{Host: "example.com", Labels: map[string]string{"team": "web"}, Module: "daily"},
+				{Host: "www.example.com", Labels: map[string]string{"team": "web"}, Module: "daily"},
+				{Host: "example.org"},
+			},
+		},
+		{
+			name:            "json",
+			file:            "targets.json",
+			content:         `[{"targets": ["example.com"], "labels": {"team": "web"}}]`,
+			expectedTargets: []Target{{Host: "example.com", Labels: map[string]string{"team": "web"}}},
+		},
+		{
+			name:            "empty_yaml",
+			file:            "empty.yml",
+			content:         "",
+			expectedTargets: nil,
+		},
+		{
+			name:          "invalid_target",
+			file:          "invalid.yml",
+			content:       "- targets: [127.0.0.1]\n",
+			expectedError: true,
+		},
+		{
+			name:          "invalid_label_name",
+			file:          "label.yml",
+			content:       "- targets: [example.com]\n  labels:\n    team-name: web\n",
+			expectedError: true,
+		},
+		{
+			name:          "unknown_field",
+			file:          "unknown.json",
+			content:       `[{"hosts": ["example.com"]}]`,
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		targets, err := readFile(writeFile(t, dir, c.file, c.content))
+		if (err != nil) != c.expectedError {
+			t.Errorf("Test case : %v failed.\nExpected error : %v\nGot : %v\n", c.name, c.expectedError, err)
+		}
+
+		if !reflect.DeepEqual(targets, c.expectedTargets) {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedTargets, targets)
+		}
+	}
+}
+
+func TestFileRun(t *testing.T) {
+	dir := t.TempDir()
+	refresh := 20 * time.Millisecond
+	d := NewFile("file/0", &config.FileDiscoveryConfig{Files: []string{filepath.Join(dir, "*.yml")}, RefreshInterval: &refresh}, log.Nop())
+
+	updates := make(chan []Target, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	writeFile(t, dir, "a.yml", "- targets: [example.com]\n")
+	go d.Run(ctx, func(source string, targets []Target) {
+		if source == "file/0" {
+			updates <- targets
+		}
+	})
+
+	next := func() []Target {
+		select {
+		case targets := <-updates:
+			return targets
+		case <-time.After(5 * time.Second):
+			t.Fatal("targets were not updated")
+			return nil
+		}
+	}
+
+	var steps = []struct {
+		name            string
+		change          func()
+		expectedTargets []Target
+	}{
+		{
+			name:            "initial_targets",
+			change:          func() {},
+			expectedTargets: []Target{{Host: "example.com"}},
+		},
+		{
+			name:            "added_file",
+			change:          func() { writeFile(t, dir, "b.yml", "- targets: [example.org]\n") },
+			expectedTargets: []Target{{Host: "example.com"}, {Host: "example.org"}},
+		},
+		{
+			name: "invalid_file_keeps_previous_targets",
+			change: func() {
+				writeFile(t, dir, "b.yml", "- targets: [example.org\n")
+				writeFile(t, dir, "a.yml", "- targets: [example.net]\n")
+			},
+			expectedTargets: []Target{{Host: "example.net"}, {Host: "example.org"}},
+		},
+		{
+			name:            "removed_file",
+			change:          func() { os.Remove(filepath.Join(dir, "b.yml")) },
+			expectedTargets: []Target{{Host: "example.net"}},
+		},
+	}
+
+	for _, s := range steps {
+		s.change()
+
+		// the intermediate states of the files may be sent before the expected one
+		var targets []Target
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+			if targets = next(); reflect.DeepEqual(targets, s.expectedTargets) {
+				break
+			}
+		}
+
+		if !reflect.DeepEqual(targets, s.expectedTargets) {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", s.name, s.expectedTargets, targets)
+		}
+	}
+}
+
+func TestFileWatchCreatedDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "targets")
+	d := NewFile("file/0", &config.FileDiscoveryConfig{Files: []string{filepath.Join(dir, "*.yml")}}, log.Nop())
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	d.watch(watcher)
+	if watched := watcher.WatchList(); len(watched) != 0 {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "missing_directory", nil, watched)
+	}
+
+	// the directory is watched at the next refresh once created
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	d.watch(watcher)
+	if watched := watcher.WatchList(); !slices.Equal(watched, []string{dir}) {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "created_directory", []string{dir}, watched)
+	}
+}
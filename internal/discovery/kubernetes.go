@@ -0,0 +1,291 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"slices"
+	"time"
+
+	log "github.com/rs/zerolog"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/config"
+	"github.com/anas-aso/ssllabs_exporter/internal/validation"
+)
+
+// annotations of the discovered Kubernetes resources
+const (
+	// the resource hosts are discovered if set to "true", unless another annotation is configured
+	AnnotationEnabled = "ssllabs.io/enabled"
+	// name of the scheduler module applying to the resource hosts
+	AnnotationModule = "ssllabs.io/module"
+)
+
+// labels of the hosts discovered from Kubernetes resources
+const (
+	labelNamespace = "kubernetes_namespace"
+	labelName      = "kubernetes_name"
+)
+
+// Gateway API HTTPRoutes, which are listed with the dynamic client since they are custom resources
+var httpRoutesResource = schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "httproutes"}
+
+// Kubernetes discovers the hosts of the annotated Ingresses and Gateway API HTTPRoutes.
+// The hosts which don't resolve to any public IP address are skipped since SSLLabs can't reach them.
+type Kubernetes struct {
+	name       string
+	namespaces []string
+	resources  []string
+	annotation string
+	refresh    time.Duration
+	logger     log.Logger
+
+	client  kubernetes.Interface
+	dynamic dynamic.Interface
+
+	// resolves the discovered hosts
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+	// latest targets sent to the updater
+	targets []Target
+}
+
+// NewKubernetes creates a Kubernetes discovery source identified by name
+func NewKubernetes(name string, cfg *config.KubernetesDiscoveryConfig, logger log.Logger) (*Kubernetes, error) {
+	// the in-cluster configuration is used if no kubeconfig is set
+	restConfig, err := clientcmd.BuildConfigFromFlags("", cfg.Kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("loading the kubernetes client configuration: %w", err)
+	}
+
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return newKubernetes(name, cfg, client, dynamicClient, logger), nil
+}
+
+func newKubernetes(name string, cfg *config.KubernetesDiscoveryConfig, client kubernetes.Interface, dynamicClient dynamic.Interface, logger log.Logger) *Kubernetes {
+	namespaces := cfg.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	resources := cfg.Resources
+	if len(resources) == 0 {
+		resources = []string{config.ResourceIngress, config.ResourceHTTPRoute}
+	}
+
+	annotation := cfg.Annotation
+	if annotation == "" {
+		annotation = AnnotationEnabled
+	}
+
+	refresh := defaultRefreshInterval
+	if cfg.RefreshInterval != nil {
+		refresh = *cfg.RefreshInterval
+	}
+
+	return &Kubernetes{
+		name:       name,
+		namespaces: namespaces,
+		resources:  resources,
+		annotation: annotation,
+		refresh:    refresh,
+		logger:     logger.With().Str("source", name).Logger(),
+		client:     client,
+		dynamic:    dynamicClient,
+		lookup:     net.DefaultResolver.LookupIPAddr,
+	}
+}
+
+// Run sends the discovered targets to the updater until the context is done.
+// The resources are listed at each refresh interval, which also catches the DNS changes.
+func (d *Kubernetes) Run(ctx context.Context, update Updater) {
+	ticker := time.NewTicker(d.refresh)
+	defer ticker.Stop()
+
+	for {
+		d.refreshTargets(ctx, update)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// list the resources and send their hosts to the updater if they changed.
+// The previous targets are kept if the resources can't be listed.
+func (d *Kubernetes) refreshTargets(ctx context.Context, update Updater) {
+	targets, err := d.discover(ctx)
+	if err != nil {
+		d.logger.Error().Err(err).Msg("failed to discover the kubernetes targets, keeping the previous ones")
+		failures.WithLabelValues(d.name).Inc()
+		return
+	}
+
+	if d.targets != nil && reflect.DeepEqual(targets, d.targets) {
+		return
+	}
+
+	if targets == nil {
+		targets = []Target{}
+	}
+	d.targets = targets
+
+	d.logger.Info().Int("targets", len(targets)).Msg("discovered targets updated")
+	update(d.name, targets)
+}
+
+// list the public hosts of the annotated resources, in the resources then namespaces order
+func (d *Kubernetes) discover(ctx context.Context) ([]Target, error) {
+	var targets []Target
+	seen := make(map[string]bool)
+
+	previous := make(map[string]bool, len(d.targets))
+	for _, t := range d.targets {
+		previous[t.Host] = true
+	}
+
+	add := func(meta metav1.Object, hosts []string) {
+		if meta.GetAnnotations()[d.annotation] != "true" {
+			return
+		}
+
+		labels := map[string]string{labelNamespace: meta.GetNamespace(), labelName: meta.GetName()}
+		for _, raw := range hosts {
+			host, err := validation.Target(raw)
+			if err != nil {
+				d.logger.Debug().Err(err).Str("namespace", meta.GetNamespace()).Str("name", meta.GetName()).Msg("skipping invalid host")
+				continue
+			}
+
+			if seen[host] {
+				continue
+			}
+			seen[host] = true
+
+			public, err := d.public(ctx, host)
+			switch {
+			case err != nil && previous[host]:
+				// a resolver failure doesn't remove the host and evict its results
+				d.logger.Warn().Err(err).Str("target", host).Msg("failed to resolve the host, keeping it")
+			case err != nil:
+				d.logger.Warn().Err(err).Str("target", host).Msg("failed to resolve the host, skipping it until the next refresh")
+				continue
+			case !public:
+				d.logger.Debug().Str("target", host).Str("namespace", meta.GetNamespace()).Str("name", meta.GetName()).Msg("skipping host without public DNS")
+				continue
+			}
+
+			targets = append(targets, Target{Host: host, Labels: labels, Module: meta.GetAnnotations()[AnnotationModule]})
+		}
+	}
+
+	for _, resource := range d.resources {
+		for _, namespace := range d.namespaces {
+			switch resource {
+			case config.ResourceIngress:
+				ingresses, err := d.client.NetworkingV1().Ingresses(namespace).List(ctx, metav1.ListOptions{})
+				if err != nil {
+					return nil, fmt.Errorf("listing ingresses: %w", err)
+				}
+
+				for i := range ingresses.Items {
+					ingress := &ingresses.Items[i]
+
+					var hosts []string
+					for _, rule := range ingress.Spec.Rules {
+						hosts = append(hosts, rule.Host)
+					}
+					for _, tls := range ingress.Spec.TLS {
+						hosts = append(hosts, tls.Hosts...)
+					}
+
+					add(ingress, hosts)
+				}
+			case config.ResourceHTTPRoute:
+				routes, err := d.dynamic.Resource(httpRoutesResource).Namespace(namespace).List(ctx, metav1.ListOptions{})
+				if apierrors.IsNotFound(err) {
+					// the Gateway API CRDs aren't installed
+					d.logger.Debug().Err(err).Msg("HTTPRoutes not available")
+					continue
+				}
+				if err != nil {
+					return nil, fmt.Errorf("listing httproutes: %w", err)
+				}
+
+				for i := range routes.Items {
+					route := &routes.Items[i]
+
+					hosts, _, err := unstructured.NestedStringSlice(route.Object, "spec", "hostnames")
+					if err != nil {
+						d.logger.Debug().Err(err).Str("namespace", route.GetNamespace()).Str("name", route.GetName()).Msg("skipping invalid httproute")
+						continue
+					}
+
+					add(route, hosts)
+				}
+			}
+		}
+	}
+
+	return targets, nil
+}
+
+// check whether the host resolves to at least one public IP address.
+// An error is returned if the host couldn't be resolved, unless it doesn't exist.
+func (d *Kubernetes) public(ctx context.Context, host string) (bool, error) {
+	addrs, err := d.lookup(ctx, host)
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return slices.ContainsFunc(addrs, func(addr net.IPAddr) bool {
+		return publicIP(addr.IP)
+	}), nil
+}
+
+// shared address space of the carrier-grade NATs (RFC 6598), not covered by net.IP.IsPrivate
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// check whether the IP address is reachable from the Internet
+func publicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedAddressSpace.Contains(ip)
+}
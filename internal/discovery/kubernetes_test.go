@@ -0,0 +1,266 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	log "github.com/rs/zerolog"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/config"
+)
+
+func ingress(namespace, name string, annotations map[string]string, hosts ...string) *networkingv1.Ingress {
+	ingress := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Annotations: annotations}}
+	for _, host := range hosts {
+		ingress.Spec.Rules = append(ingress.Spec.Rules, networkingv1.IngressRule{Host: host})
+	}
+	ingress.Spec.TLS = []networkingv1.IngressTLS{{Hosts: hosts}}
+
+	return ingress
+}
+
+func httpRoute(namespace, name string, annotations map[string]string, hosts ...string) *unstructured.Unstructured {
+	hostnames := make([]interface{}, 0, len(hosts))
+	for _, host := range hosts {
+		hostnames = append(hostnames, host)
+	}
+
+	route := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "gateway.networking.k8s.io/v1",
+		"kind":       "HTTPRoute",
+		"spec":       map[string]interface{}{"hostnames": hostnames},
+	}}
+	route.SetNamespace(namespace)
+	route.SetName(name)
+	route.SetAnnotations(annotations)
+
+	return route
+}
+
+// fake DNS where the hosts starting with "internal" resolve to private addresses
+func fakeLookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	switch host {
+	case "internal.example.com":
+		return []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}}, nil
+	case "internal-cgnat.example.com":
+		return []net.IPAddr{{IP: net.ParseIP("100.64.0.1")}}, nil
+	case "unresolved.example.com":
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	return []net.IPAddr{{IP: net.ParseIP("10.0.0.2")}, {IP: net.ParseIP("93.184.215.14")}}, nil
+}
+
+func newFakeKubernetes(cfg *config.KubernetesDiscoveryConfig, objects []runtime.Object, routes ...runtime.Object) *Kubernetes {
+	scheme := runtime.NewScheme()
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(scheme,
+		map[schema.GroupVersionResource]string{httpRoutesResource: "HTTPRouteList"}, routes...)
+
+	d := newKubernetes("kubernetes/0", cfg, fake.NewClientset(objects...), dynamicClient, log.Nop())
+	d.lookup = fakeLookup
+
+	return d
+}
+
+func TestKubernetesDiscover(t *testing.T) {
+	enabled := map[string]string{AnnotationEnabled: "true"}
+
+	objects := []runtime.Object{
+		ingress("web", "site", enabled, "example.com", "www.example.com"),
+		ingress("web", "disabled", map[string]string{AnnotationEnabled: "false"}, "disabled.example.com"),
+		ingress("web", "not-annotated", nil, "not-annotated.example.com"),
+		ingress("internal", "admin", enabled, "internal.example.com", "internal-cgnat.example.com", "unresolved.example.com", "admin.svc.cluster.local", "*.example.com"),
+		ingress("api", "api", map[string]string{AnnotationEnabled: "true", AnnotationModule: "critical"}, "api.example.com"),
+		ingress("custom", "custom", map[string]string{"example.com/tls-check": "true"}, "custom.example.com"),
+	}
+	routes := []runtime.Object{
+		httpRoute("web", "route", enabled, "route.example.com", "example.com"),
+		httpRoute("web", "route-disabled", nil, "route-disabled.example.com"),
+	}
+
+	var cases = []struct {
+		name            string
+		cfg             config.KubernetesDiscoveryConfig
+		expectedTargets []Target
+	}{
+		{
+			name: "all_resources",
+			cfg:  config.KubernetesDiscoveryConfig{},
+			expectedTargets: []Target{
+				{Host: "api.example.com", Labels: map[string]string{labelNamespace: "api", labelName: "api"}, Module: "critical"},
+				{Host: "example.com", Labels: map[string]string{labelNamespace: "web", labelName: "site"}},
+				{Host: "www.example.com", Labels: map[string]string{labelNamespace: "web", labelName: "site"}},
+				{Host: "route.example.com", Labels: map[string]string{labelNamespace: "web", labelName: "route"}},
+			},
+		},
+		{
+			name: "namespaces",
+			cfg:  config.KubernetesDiscoveryConfig{Namespaces: []string{"api", "internal"}},
+			expectedTargets: []Target{
+				{Host: "api.example.com", Labels: map[string]string{labelNamespace: "api", labelName: "api"}, Module: "critical"},
+			},
+		},
+		{
+			name: "httproutes_only",
+			cfg:  config.KubernetesDiscoveryConfig{Resources: []string{config.ResourceHTTPRoute}},
+			expectedTargets: []Target{
+				{Host: "route.example.com", Labels: map[string]string{labelNamespace: "web", labelName: "route"}},
+				{Host: "example.com", Labels: map[string]string{labelNamespace: "web", labelName: "route"}},
+			},
+		},
+		{
+			name: "custom_annotation",
+			cfg:  config.KubernetesDiscoveryConfig{Resources: []string{config.ResourceIngress}, Annotation: "example.com/tls-check"},
+			expectedTargets: []Target{
+				{Host: "custom.example.com", Labels: map[string]string{labelNamespace: "custom", labelName: "custom"}},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		d := newFakeKubernetes(&c.cfg, objects, routes...)
+
+		targets, err := d.discover(context.Background())
+		if err != nil {
+			t.Errorf("Test case : %v failed.\nExpected error : %v\nGot : %v\n", c.name, nil, err)
+		}
+
+		if !reflect.DeepEqual(targets, c.expectedTargets) {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedTargets, targets)
+		}
+	}
+}
+
+func TestKubernetesRefreshTargets(t *testing.T) {
+	enabled := map[string]string{AnnotationEnabled: "true"}
+	d := newFakeKubernetes(&config.KubernetesDiscoveryConfig{Resources: []string{config.ResourceIngress}}, []runtime.Object{ingress("web", "site", enabled, "example.com")})
+
+	var updates [][]Target
+	update := func(source string, targets []Target) {
+		updates = append(updates, targets)
+	}
+
+	// unchanged targets are only sent once
+	d.refreshTargets(context.Background(), update)
+	d.refreshTargets(context.Background(), update)
+
+	// removed resources remove their targets
+	if err := d.client.NetworkingV1().Ingresses("web").Delete(context.Background(), "site", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	d.refreshTargets(context.Background(), update)
+
+	// the targets are kept if the resources can't be listed
+	d.client.(*fake.Clientset).PrependReactor("list", "ingresses", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection refused")
+	})
+	d.refreshTargets(context.Background(), update)
+
+	expected := [][]Target{
+		{{Host: "example.com", Labels: map[string]string{labelNamespace: "web", labelName: "site"}}},
+		{},
+	}
+	if !reflect.DeepEqual(updates, expected) {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "updates", expected, updates)
+	}
+}
+
+func TestKubernetesResolverFailure(t *testing.T) {
+	enabled := map[string]string{AnnotationEnabled: "true"}
+	d := newFakeKubernetes(&config.KubernetesDiscoveryConfig{Resources: []string{config.ResourceIngress}}, []runtime.Object{ingress("web", "site", enabled, "example.com")})
+
+	var updates [][]Target
+	update := func(source string, targets []Target) {
+		updates = append(updates, targets)
+	}
+	d.refreshTargets(context.Background(), update)
+
+	// the hosts already discovered are kept when the resolver fails, the new ones skipped
+	if _, err := d.client.NetworkingV1().Ingresses("web").Create(context.Background(), ingress("web", "new", enabled, "new.example.com"), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	d.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		return nil, &net.DNSError{Err: "i/o timeout", Name: host, IsTimeout: true, IsTemporary: true}
+	}
+	d.refreshTargets(context.Background(), update)
+
+	// the hosts which don't exist anymore are removed
+	d.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	d.refreshTargets(context.Background(), update)
+
+	expected := [][]Target{
+		{{Host: "example.com", Labels: map[string]string{labelNamespace: "web", labelName: "site"}}},
+		{},
+	}
+	if !reflect.DeepEqual(updates, expected) {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "updates", expected, updates)
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	var cases = []struct {
+		ip             string
+		expectedResult bool
+	}{
+		{ip: "93.184.215.14", expectedResult: true},
+		{ip: "2606:2800:21f:cb07:6820:80da:af6b:8b2c", expectedResult: true},
+		{ip: "10.1.2.3", expectedResult: false},
+		{ip: "172.16.0.1", expectedResult: false},
+		{ip: "192.168.1.1", expectedResult: false},
+		{ip: "100.100.0.1", expectedResult: false},
+		{ip: "127.0.0.1", expectedResult: false},
+		{ip: "169.254.169.254", expectedResult: false},
+		{ip: "fd00::1", expectedResult: false},
+		{ip: "::1", expectedResult: false},
+	}
+
+	for _, c := range cases {
+		if got := publicIP(net.ParseIP(c.ip)); got != c.expectedResult {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.ip, c.expectedResult, got)
+		}
+	}
+}
+
+func TestKubernetesWithoutGatewayAPI(t *testing.T) {
+	enabled := map[string]string{AnnotationEnabled: "true"}
+	d := newFakeKubernetes(&config.KubernetesDiscoveryConfig{}, []runtime.Object{ingress("web", "site", enabled, "example.com")})
+
+	// the HTTPRoutes can't be listed if the Gateway API CRDs aren't installed
+	d.dynamic.(*dynamicfake.FakeDynamicClient).PrependReactor("list", "httproutes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewNotFound(httpRoutesResource.GroupResource(), "")
+	})
+
+	targets, err := d.discover(context.Background())
+	expected := []Target{{Host: "example.com", Labels: map[string]string{labelNamespace: "web", labelName: "site"}}}
+	if err != nil || !reflect.DeepEqual(targets, expected) {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v, %v\n", "without_gateway_api", expected, targets, err)
+	}
+}
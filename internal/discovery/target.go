@@ -0,0 +1,69 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/config"
+	"github.com/anas-aso/ssllabs_exporter/internal/validation"
+)
+
+var failures = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ssllabs_exporter_discovery_failures_total",
+		Help: "Number of failed targets discoveries by source",
+	},
+	[]string{"source"},
+)
+
+// Target is a discovered host to assess
+type Target struct {
+	Host   string            `json:"target"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// name of the scheduler module applying to the target, the default settings if empty
+	Module string `json:"module,omitempty"`
+}
+
+// Group is a list of targets sharing the same labels and module, in the Prometheus file_sd format
+type Group = config.TargetGroup
+
+// Updater receives all the targets of a source each time they change
+type Updater func(source string, targets []Target)
+
+// Targets lists the normalized targets of the groups
+func Targets(groups []Group) ([]Target, error) {
+	var targets []Target
+
+	for i, g := range groups {
+		if err := validation.Labels(g.Labels); err != nil {
+			return nil, fmt.Errorf("group %d: %w", i, err)
+		}
+
+		for _, raw := range g.Targets {
+			host, err := validation.Target(raw)
+			if err != nil {
+				return nil, fmt.Errorf("group %d: %w", i, err)
+			}
+
+			targets = append(targets, Target{Host: host, Labels: g.Labels, Module: g.Module})
+		}
+	}
+
+	return targets, nil
+}
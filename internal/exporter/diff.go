@@ -0,0 +1,228 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// categories of the changes between two assessments
+const (
+	ChangeGrade         = "grade"
+	ChangeEndpoint      = "endpoint"
+	ChangeProtocol      = "protocol"
+	ChangeCertificate   = "certificate"
+	ChangeHSTS          = "hsts"
+	ChangeCipherSuite   = "cipher_suite"
+	ChangeVulnerability = "vulnerability"
+)
+
+// Snapshot holds the properties of a successful assessment compared with the next ones
+type Snapshot struct {
+	AssessmentTime     time.Time          `json:"assessment_time"`
+	Grade              string             `json:"grade"`
+	Endpoints          []EndpointSnapshot `json:"endpoints"`
+	CertificateSerials []string           `json:"certificate_serials"`
+	LeafSerials        []string           `json:"leaf_certificate_serials"`
+	Protocols          []string           `json:"protocols"`
+	WeakSuites         []string           `json:"weak_suites"`
+	Vulnerabilities    []string           `json:"vulnerabilities"`
+}
+
+// EndpointSnapshot holds the properties of one of the assessed endpoints
+type EndpointSnapshot struct {
+	IPAddress string `json:"ip_address"`
+	Grade     string `json:"grade,omitempty"`
+	HSTS      string `json:"hsts,omitempty"`
+}
+
+// Change is a difference between two assessments of the same target.
+// Previous is empty for added items and Current for removed ones.
+type Change struct {
+	Category string `json:"category"`
+	Endpoint string `json:"endpoint,omitempty"`
+	Previous string `json:"previous,omitempty"`
+	Current  string `json:"current,omitempty"`
+}
+
+// String describes the change for logging purposes
+func (c Change) String() string {
+	subject := c.Category
+	if c.Endpoint != "" {
+		subject += " of " + c.Endpoint
+	}
+
+	switch {
+	case c.Previous == "":
+		return fmt.Sprintf("%v added: %v", subject, c.Current)
+	case c.Current == "":
+		return fmt.Sprintf("%v removed: %v", subject, c.Previous)
+	default:
+		return fmt.Sprintf("%v changed: %v -> %v", subject, c.Previous, c.Current)
+	}
+}
+
+// NewSnapshot summarizes a successful assessment result.
+// The weak suites are the ones SSLLabs considers insecure.
+func NewSnapshot(result *Result) Snapshot {
+	snapshot := Snapshot{
+		AssessmentTime:     result.Start,
+		Grade:              result.Grade(),
+		Endpoints:          []EndpointSnapshot{},
+		CertificateSerials: []string{},
+	}
+
+	if result.Info == nil {
+		snapshot.Protocols, snapshot.WeakSuites, snapshot.Vulnerabilities, snapshot.LeafSerials = []string{}, []string{}, []string{}, []string{}
+		return snapshot
+	}
+
+	if result.Info.TestTime > 0 {
+		snapshot.AssessmentTime = time.UnixMilli(result.Info.TestTime)
+	}
+
+	protocols := make(map[string]bool)
+	weakSuites := make(map[string]bool)
+	vulnerabilities := make(map[string]bool)
+	leaves := make(map[string]bool)
+
+	for _, e := range result.Info.Endpoints {
+		endpoint := EndpointSnapshot{IPAddress: e.IPAddress, Grade: e.Grade}
+
+		if e.Details != nil {
+			for _, p := range e.Details.Protocols {
+				protocols[p.Name+" "+p.Version] = true
+			}
+
+			// the chains start with the leaf certificate
+			for _, chain := range e.Details.CertChains {
+				if len(chain.CertIDs) > 0 {
+					leaves[chain.CertIDs[0]] = true
+				}
+			}
+
+			for _, suites := range e.Details.Suites {
+				for _, s := range suites.List {
+					if s.Q != nil && *s.Q == 0 {
+						weakSuites[s.Name] = true
+					}
+				}
+			}
+
+			for _, v := range Vulnerabilities(e.Details) {
+				vulnerabilities[v] = true
+			}
+
+			if hsts := e.Details.HSTSPolicy; hsts != nil {
+				endpoint.HSTS = hsts.Status
+				if hsts.MaxAge > 0 {
+					endpoint.HSTS += fmt.Sprintf(" (max-age=%d)", hsts.MaxAge)
+				}
+			}
+		}
+
+		snapshot.Endpoints = append(snapshot.Endpoints, endpoint)
+	}
+
+	leafSerials := make(map[string]bool)
+	for _, c := range result.Info.Certs {
+		snapshot.CertificateSerials = append(snapshot.CertificateSerials, c.SerialNumber)
+		if leaves[c.ID] {
+			leafSerials[c.SerialNumber] = true
+		}
+	}
+
+	snapshot.Protocols = sortedKeys(protocols)
+	snapshot.WeakSuites = sortedKeys(weakSuites)
+	snapshot.Vulnerabilities = sortedKeys(vulnerabilities)
+	snapshot.LeafSerials = sortedKeys(leafSerials)
+
+	return snapshot
+}
+
+// Diff lists the changes from the previous to the current assessment of a target
+func Diff(previous, current Snapshot) []Change {
+	changes := []Change{}
+
+	if previous.Grade != current.Grade {
+		changes = append(changes, Change{Category: ChangeGrade, Previous: previous.Grade, Current: current.Grade})
+	}
+
+	previousEndpoints := make(map[string]EndpointSnapshot)
+	for _, e := range previous.Endpoints {
+		previousEndpoints[e.IPAddress] = e
+	}
+	currentEndpoints := make(map[string]EndpointSnapshot)
+	for _, e := range current.Endpoints {
+		currentEndpoints[e.IPAddress] = e
+	}
+
+	changes = append(changes, diffSets(ChangeEndpoint, sortedKeys(previousEndpoints), sortedKeys(currentEndpoints))...)
+
+	// endpoints grades and HSTS policies are only compared for the endpoints in both assessments
+	for _, ip := range sortedKeys(currentEndpoints) {
+		p, found := previousEndpoints[ip]
+		if !found {
+			continue
+		}
+
+		c := currentEndpoints[ip]
+		if p.Grade != c.Grade {
+			changes = append(changes, Change{Category: ChangeGrade, Endpoint: ip, Previous: p.Grade, Current: c.Grade})
+		}
+		if p.HSTS != c.HSTS {
+			changes = append(changes, Change{Category: ChangeHSTS, Endpoint: ip, Previous: p.HSTS, Current: c.HSTS})
+		}
+	}
+
+	changes = append(changes, diffSets(ChangeProtocol, previous.Protocols, current.Protocols)...)
+	changes = append(changes, diffSets(ChangeCertificate, previous.CertificateSerials, current.CertificateSerials)...)
+	changes = append(changes, diffSets(ChangeCipherSuite, previous.WeakSuites, current.WeakSuites)...)
+	changes = append(changes, diffSets(ChangeVulnerability, previous.Vulnerabilities, current.Vulnerabilities)...)
+
+	return changes
+}
+
+// list the items removed from the previous set then the ones added to the current set
+func diffSets(category string, previous, current []string) []Change {
+	var changes []Change
+
+	for _, item := range previous {
+		if !slices.Contains(current, item) {
+			changes = append(changes, Change{Category: category, Previous: item})
+		}
+	}
+
+	for _, item := range current {
+		if !slices.Contains(previous, item) {
+			changes = append(changes, Change{Category: category, Current: item})
+		}
+	}
+
+	return changes
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,152 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	ssllabsApi "github.com/essentialkaos/sslscan/v13"
+)
+
+func TestNewSnapshot(t *testing.T) {
+	testTime := time.UnixMilli(time.Now().UnixMilli())
+	insecure := 0
+
+	result := NewResult("example.com", testTime.Add(-time.Minute), time.Second, &ssllabsApi.AnalyzeInfo{
+		TestTime: testTime.UnixMilli(),
+		Endpoints: []*ssllabsApi.EndpointInfo{
+			{
+				IPAddress: "192.0.2.1",
+				Grade:     "B",
+				Details: &ssllabsApi.EndpointDetails{
+					Protocols: []*ssllabsApi.Protocol{{Name: "TLS", Version: "1.3"}, {Name: "TLS", Version: "1.0"}},
+					Suites: []*ssllabsApi.ProtocolSuites{{List: []*ssllabsApi.Suite{
+						{Name: "TLS_AES_128_GCM_SHA256"},
+						{Name: "TLS_RSA_WITH_RC4_128_SHA", Q: &insecure},
+					}}},
+					CertChains: []*ssllabsApi.ChainCert{{CertIDs: []string{"leaf", "intermediate"}}},
+					HSTSPolicy: &ssllabsApi.HSTSPolicy{Status: "present", MaxAge: 31536000},
+					Poodle:     true,
+				},
+			},
+			{IPAddress: "192.0.2.2", StatusMessage: "Unable to connect to the server"},
+		},
+		Certs: []*ssllabsApi.Cert{{ID: "leaf", SerialNumber: "01"}, {ID: "intermediate", SerialNumber: "02"}},
+	}, nil)
+
+	expected := Snapshot{
+		AssessmentTime: testTime,
+		Grade:          "B",
+		Endpoints: []EndpointSnapshot{
+			{IPAddress: "192.0.2.1", Grade: "B", HSTS: "present (max-age=31536000)"},
+			{IPAddress: "192.0.2.2"},
+		},
+		CertificateSerials: []string{"01", "02"},
+		LeafSerials:        []string{"01"},
+		Protocols:          []string{"TLS 1.0", "TLS 1.3"},
+		WeakSuites:         []string{"TLS_RSA_WITH_RC4_128_SHA"},
+		Vulnerabilities:    []string{"poodle"},
+	}
+
+	if snapshot := NewSnapshot(result); !reflect.DeepEqual(snapshot, expected) {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "snapshot", expected, snapshot)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	previous := Snapshot{
+		Grade: "A",
+		Endpoints: []EndpointSnapshot{
+			{IPAddress: "192.0.2.1", Grade: "A", HSTS: "present (max-age=31536000)"},
+			{IPAddress: "192.0.2.2", Grade: "A"},
+		},
+		CertificateSerials: []string{"01"},
+		Protocols:          []string{"TLS 1.2", "TLS 1.3"},
+		WeakSuites:         []string{},
+		Vulnerabilities:    []string{},
+	}
+
+	var cases = []struct {
+		name            string
+		update          func(s *Snapshot)
+		expectedChanges []Change
+	}{
+		{
+			name:            "unchanged",
+			update:          func(s *Snapshot) {},
+			expectedChanges: []Change{},
+		},
+		{
+			name: "regression",
+			update: func(s *Snapshot) {
+				s.Grade = "B"
+				s.Endpoints[0] = EndpointSnapshot{IPAddress: "192.0.2.1", Grade: "B", HSTS: "absent"}
+				s.Protocols = []string{"TLS 1.0", "TLS 1.2", "TLS 1.3"}
+				s.WeakSuites = []string{"TLS_RSA_WITH_RC4_128_SHA"}
+				s.Vulnerabilities = []string{"poodle"}
+			},
+			expectedChanges: []Change{
+				{Category: ChangeGrade, Previous: "A", Current: "B"},
+				{Category: ChangeGrade, Endpoint: "192.0.2.1", Previous: "A", Current: "B"},
+				{Category: ChangeHSTS, Endpoint: "192.0.2.1", Previous: "present (max-age=31536000)", Current: "absent"},
+				{Category: ChangeProtocol, Current: "TLS 1.0"},
+				{Category: ChangeCipherSuite, Current: "TLS_RSA_WITH_RC4_128_SHA"},
+				{Category: ChangeVulnerability, Current: "poodle"},
+			},
+		},
+		{
+			name: "new_certificate_and_endpoint",
+			update: func(s *Snapshot) {
+				s.Endpoints = []EndpointSnapshot{s.Endpoints[0], {IPAddress: "192.0.2.3", Grade: "A"}}
+				s.CertificateSerials = []string{"02"}
+			},
+			expectedChanges: []Change{
+				{Category: ChangeEndpoint, Previous: "192.0.2.2"},
+				{Category: ChangeEndpoint, Current: "192.0.2.3"},
+				{Category: ChangeCertificate, Previous: "01"},
+				{Category: ChangeCertificate, Current: "02"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		current := previous
+		current.Endpoints = append([]EndpointSnapshot{}, previous.Endpoints...)
+		c.update(&current)
+
+		if changes := Diff(previous, current); !reflect.DeepEqual(changes, c.expectedChanges) {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedChanges, changes)
+		}
+	}
+}
+
+func TestChangeString(t *testing.T) {
+	var cases = []struct {
+		change   Change
+		expected string
+	}{
+		{Change{Category: ChangeProtocol, Current: "TLS 1.0"}, "protocol added: TLS 1.0"},
+		{Change{Category: ChangeCertificate, Previous: "01"}, "certificate removed: 01"},
+		{Change{Category: ChangeGrade, Endpoint: "192.0.2.1", Previous: "A", Current: "B"}, "grade of 192.0.2.1 changed: A -> B"},
+	}
+
+	for _, c := range cases {
+		if got := c.change.String(); got != c.expected {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.change.Category, c.expected, got)
+		}
+	}
+}
@@ -19,17 +19,128 @@ import (
 	"time"
 
 	"github.com/anas-aso/ssllabs_exporter/internal/ssllabs"
+	ssllabsApi "github.com/essentialkaos/sslscan/v13"
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/rs/zerolog"
 )
 
 const probeSuccessMetricName = "ssllabs_probe_success"
 
+// failure reasons exposed by the ssllabs_probe_failure_reason metric
+// mapped from the SSLLabs assessment status
+var failureReasons = map[string]string{
+	ssllabs.StatusDeadlineExceeded: "timeout",
+	ssllabs.StatusAborted:          "aborted",
+	ssllabs.StatusRateLimited:      "rate_limit",
+	ssllabs.StatusDNSError:         "dns",
+	ssllabs.StatusServerError:      "server_error",
+	ssllabs.StatusHTTPError:        "api_error",
+	ssllabs.StatusError:            "assessment_error",
+}
+
+// Result of an assessment
+type Result struct {
+	Target string
+
+	// when the assessment was started
+	Start time.Time
+
+	// how long the assessment took
+	Duration time.Duration
+
+	// SSLLabs assessment, nil if it could not be fetched
+	Info *ssllabsApi.AnalyzeInfo
+
+	// why the assessment failed, nil if it succeeded
+	Err error
+
+	// evaluation of the policy rules applying to the target, only for successful assessments
+	Policies []PolicyResult
+
+	// user supplied labels added to all the assessment metrics
+	Labels map[string]string
+
+	// Prometheus Registry with the assessment metrics
+	Registry prometheus.Gatherer
+}
+
+type labelsKey struct{}
+
+// WithLabels returns a copy of the context adding the labels to the metrics of the assessments it runs.
+// The labels must be validated beforehand.
+func WithLabels(ctx context.Context, labels map[string]string) context.Context {
+	return context.WithValue(ctx, labelsKey{}, labels)
+}
+
 // Handle runs SSLLabs assessment on the specified target
-// and returns a Prometheus Registry with the results
-func Handle(ctx context.Context, logger log.Logger, target string) prometheus.Gatherer {
+// and returns the results with their Prometheus Registry
+func Handle(ctx context.Context, logger log.Logger, target string) *Result {
+	start := time.Now()
+
+	info, err := ssllabs.Analyze(ctx, logger, target)
+	if err != nil {
+		logger.Error().Err(err).Str("target", target).Msg("assessment failed")
+	}
+
+	result := NewResult(target, start, time.Since(start), info, err)
+	if labels, _ := ctx.Value(labelsKey{}).(map[string]string); len(labels) > 0 {
+		result = result.WithLabels(labels)
+	}
+
+	return result
+}
+
+// NewResult creates the results of an assessment, such as the ones
+// restored from a shared cache, with their Prometheus Registry
+// and the evaluation of the policy rules.
+func NewResult(target string, start time.Time, duration time.Duration, info *ssllabsApi.AnalyzeInfo, err error) *Result {
+	var policies []PolicyResult
+	if err == nil && info != nil {
+		policies = evaluatePolicies(target, info)
+	}
+
+	return &Result{
+		Target:   target,
+		Start:    start,
+		Duration: duration,
+		Info:     info,
+		Err:      err,
+		Policies: policies,
+		Registry: newRegistry(target, start, duration, info, err, policies, nil),
+	}
+}
+
+// WithLabels returns a copy of the results with their metrics labeled with the labels,
+// which replace the previous ones. The labels must be validated beforehand.
+func (r *Result) WithLabels(labels map[string]string) *Result {
+	labeled := *r
+	labeled.Labels = labels
+	labeled.Registry = newRegistry(r.Target, r.Start, r.Duration, r.Info, r.Err, r.Policies, labels)
+
+	return &labeled
+}
+
+// Grade returns the lowest grade of the assessed endpoints,
+// empty if the assessment failed or no endpoint was graded
+func (r *Result) Grade() string {
+	if r.Err != nil || r.Info == nil {
+		return ""
+	}
+
+	return endpointsLowestGrade(r.Info.Endpoints)
+}
+
+// Interrupted returns the results of a probe which stopped waiting
+// for an assessment started at the provided time
+func Interrupted(target string, start time.Time, err error) *Result {
+	return NewResult(target, start, time.Since(start), nil, err)
+}
+
+// create a registry with the assessment results, all the metrics having the labels
+func newRegistry(target string, start time.Time, duration time.Duration, result *ssllabsApi.AnalyzeInfo, err error, policies []PolicyResult, labels map[string]string) prometheus.Gatherer {
 	var (
 		registry           = prometheus.NewRegistry()
+		registerer         = prometheus.WrapRegistererWith(labels, registry)
 		probeDurationGauge = prometheus.NewGauge(prometheus.GaugeOpts{
 			Name: "ssllabs_probe_duration_seconds",
 			Help: "Displays how long the assessment took to complete in seconds",
@@ -38,6 +149,10 @@ func Handle(ctx context.Context, logger log.Logger, target string) prometheus.Ga
 			Name: probeSuccessMetricName,
 			Help: "Displays whether the assessment succeeded or not",
 		})
+		probeFailureReasonGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ssllabs_probe_failure_reason",
+			Help: "Displays why the assessment failed (value of 1) if it did",
+		}, []string{"reason"})
 
 		probeGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "ssllabs_grade",
@@ -49,20 +164,21 @@ func Handle(ctx context.Context, logger log.Logger, target string) prometheus.Ga
 		})
 	)
 
-	registry.MustRegister(probeDurationGauge)
-	registry.MustRegister(probeSuccessGauge)
-	registry.MustRegister(probeGaugeVec)
-	registry.MustRegister(probeTimeGauge)
+	registerer.MustRegister(probeDurationGauge)
+	registerer.MustRegister(probeSuccessGauge)
+	registerer.MustRegister(probeFailureReasonGaugeVec)
+	registerer.MustRegister(probeGaugeVec)
+	registerer.MustRegister(probeTimeGauge)
 
-	start := time.Now()
 	probeTimeGauge.Set(float64(start.Unix()))
+	probeDurationGauge.Set(duration.Seconds())
 
-	result, err := ssllabs.Analyze(ctx, logger, target)
-
-	probeDurationGauge.Set(time.Since(start).Seconds())
+	for _, reason := range failureReasons {
+		probeFailureReasonGaugeVec.WithLabelValues(reason).Set(0)
+	}
 
 	if err != nil {
-		logger.Error().Err(err).Str("target", target).Msg("assessment failed")
+		probeFailureReasonGaugeVec.WithLabelValues(failureReason(err)).Set(1)
 		// set grade to -1 if the assessment failed
 		probeGaugeVec.WithLabelValues("-").Set(-1)
 
@@ -80,9 +196,54 @@ func Handle(ctx context.Context, logger log.Logger, target string) prometheus.Ga
 		probeGaugeVec.WithLabelValues("-").Set(0)
 	}
 
+	if len(policies) > 0 {
+		registerPolicies(registerer, target, policies)
+	}
+
 	return registry
 }
 
+// add the policy rules evaluation to the registry
+func registerPolicies(registerer prometheus.Registerer, target string, policies []PolicyResult) {
+	var (
+		violationGaugeVec = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ssllabs_policy_violation",
+			Help: "Displays whether the target violates the policy rule (value of 1) or not",
+		}, []string{"target", "rule"})
+		passedGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ssllabs_policy_passed",
+			Help: "Displays whether the target complies with all the policy rules applying to it",
+		}, []string{"target"})
+	)
+
+	registerer.MustRegister(violationGaugeVec)
+	registerer.MustRegister(passedGauge)
+
+	for _, p := range policies {
+		violated := 0.0
+		if !p.Passed {
+			violated = 1
+		}
+		violationGaugeVec.WithLabelValues(target, p.Rule).Set(violated)
+	}
+
+	if PoliciesPassed(policies) {
+		passedGauge.WithLabelValues(target).Set(1)
+	} else {
+		passedGauge.WithLabelValues(target).Set(0)
+	}
+}
+
+// map the assessment error to one of the failure reasons
+func failureReason(err error) string {
+	reason, ok := failureReasons[ssllabs.ErrorStatus(err)]
+	if !ok {
+		return failureReasons[ssllabs.StatusHTTPError]
+	}
+
+	return reason
+}
+
 // Failed checks whether the assessment failed or not
 func Failed(registry prometheus.Gatherer) bool {
 	metrics, err := registry.Gather()
@@ -15,8 +15,13 @@
 package exporter
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
+	ssllabsApi "github.com/essentialkaos/sslscan/v13"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -62,3 +67,82 @@ func TestFailed(t *testing.T) {
 		}
 	}
 }
+
+func TestFailureReason(t *testing.T) {
+	var cases = []struct {
+		name           string
+		err            error
+		expectedResult string
+	}{
+		{
+			name:           "deadline_exceeded",
+			err:            fmt.Errorf("fetching updates: %w", context.DeadlineExceeded),
+			expectedResult: "timeout",
+		},
+		{
+			name:           "canceled",
+			err:            context.Canceled,
+			expectedResult: "aborted",
+		},
+		{
+			name:           "rate_limited",
+			err:            &ssllabsApi.HTTPError{StatusCode: 429},
+			expectedResult: "rate_limit",
+		},
+		{
+			name:           "overloaded",
+			err:            &ssllabsApi.HTTPError{StatusCode: 529},
+			expectedResult: "rate_limit",
+		},
+		{
+			name:           "server_error",
+			err:            &ssllabsApi.HTTPError{StatusCode: 500},
+			expectedResult: "server_error",
+		},
+		{
+			name:           "client_error",
+			err:            &ssllabsApi.HTTPError{StatusCode: 400},
+			expectedResult: "api_error",
+		},
+		{
+			name:           "network_error",
+			err:            errors.New("connection refused"),
+			expectedResult: "api_error",
+		},
+	}
+
+	for _, c := range cases {
+		result := failureReason(c.err)
+		if result != c.expectedResult {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedResult, result)
+		}
+	}
+}
+
+func TestResultWithLabels(t *testing.T) {
+	info := &ssllabsApi.AnalyzeInfo{Host: "example.com", Endpoints: []*ssllabsApi.EndpointInfo{{Grade: "A"}}}
+	result := NewResult("example.com", time.Now(), time.Second, info, nil)
+	labeled := result.WithLabels(map[string]string{"team": "web"})
+
+	mfs, err := labeled.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			found := false
+			for _, l := range m.GetLabel() {
+				found = found || (l.GetName() == "team" && l.GetValue() == "web")
+			}
+
+			if !found {
+				t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", mf.GetName(), "team=web", m.GetLabel())
+			}
+		}
+	}
+
+	if labeled.Grade() != "A" || result.Labels != nil {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "copied_result", "unlabeled original", result.Labels)
+	}
+}
@@ -66,3 +66,20 @@ func endpointsLowestGrade(ep []*ssllabsApi.EndpointInfo) (result string) {
 
 	return
 }
+
+// GradeBelow checks whether the grade is lower than the minimum one.
+// Missing and unknown grades are lower than any known grade.
+func GradeBelow(grade, minimum string) bool {
+	value, ok := gradesMapping[grade]
+	if !ok {
+		value = gradesMapping["undef"]
+	}
+
+	return value < gradesMapping[minimum]
+}
+
+// KnownGrade checks whether the grade is one of the SSLLabs grades
+func KnownGrade(grade string) bool {
+	_, ok := gradesMapping[grade]
+	return ok && grade != "undef"
+}
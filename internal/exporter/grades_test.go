@@ -105,3 +105,24 @@ func TestEndpointsLowestGrade(t *testing.T) {
 		}
 	}
 }
+
+func TestGradeBelow(t *testing.T) {
+	var cases = []struct {
+		grade          string
+		minimum        string
+		expectedResult bool
+	}{
+		{grade: "A+", minimum: "A", expectedResult: false},
+		{grade: "A", minimum: "A", expectedResult: false},
+		{grade: "A-", minimum: "A", expectedResult: true},
+		{grade: "T", minimum: "F", expectedResult: true},
+		{grade: "", minimum: "F", expectedResult: true},
+		{grade: "X", minimum: "F", expectedResult: true},
+	}
+
+	for _, c := range cases {
+		if got := GradeBelow(c.grade, c.minimum); got != c.expectedResult {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.grade+"_below_"+c.minimum, c.expectedResult, got)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	ssllabsApi "github.com/essentialkaos/sslscan/v13"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/config"
+)
+
+var (
+	policiesMu sync.RWMutex
+	policies   []config.PolicyRule
+)
+
+// PolicyResult is the evaluation of a policy rule against an assessment
+type PolicyResult struct {
+	Rule       string   `json:"rule"`
+	Passed     bool     `json:"passed"`
+	Violations []string `json:"violations,omitempty"`
+}
+
+// SetPolicies sets the policy rules evaluated against the successful assessments
+func SetPolicies(rules []config.PolicyRule) {
+	policiesMu.Lock()
+	defer policiesMu.Unlock()
+
+	policies = rules
+}
+
+// PoliciesPassed checks whether none of the policy rules is violated
+func PoliciesPassed(results []PolicyResult) bool {
+	for _, r := range results {
+		if !r.Passed {
+			return false
+		}
+	}
+
+	return true
+}
+
+// evaluate the policy rules applying to the target
+func evaluatePolicies(target string, info *ssllabsApi.AnalyzeInfo) []PolicyResult {
+	policiesMu.RLock()
+	defer policiesMu.RUnlock()
+
+	var results []PolicyResult
+	for i := range policies {
+		rule := &policies[i]
+		if !rule.Applies(target) {
+			continue
+		}
+
+		violations := policyViolations(rule, info)
+		results = append(results, PolicyResult{
+			Rule:       rule.Name,
+			Passed:     len(violations) == 0,
+			Violations: violations,
+		})
+	}
+
+	return results
+}
+
+// describe the rule conditions the assessment doesn't meet.
+// The endpoints without details (e.g unreachable ones) are ignored.
+func policyViolations(rule *config.PolicyRule, info *ssllabsApi.AnalyzeInfo) []string {
+	var violations []string
+
+	if rule.MinGrade != "" {
+		if grade := endpointsLowestGrade(info.Endpoints); GradeBelow(grade, rule.MinGrade) {
+			violations = append(violations, fmt.Sprintf("grade %q is below %s", grade, rule.MinGrade))
+		}
+	}
+
+	certs := make(map[string]*ssllabsApi.Cert)
+	for _, c := range info.Certs {
+		certs[c.ID] = c
+	}
+
+	for _, e := range info.Endpoints {
+		d := e.Details
+		if d == nil {
+			continue
+		}
+
+		if minProtocol := rule.MinTLSProtocol(); minProtocol > 0 {
+			for _, p := range d.Protocols {
+				if p.Name != "TLS" || p.ID < minProtocol {
+					violations = append(violations, fmt.Sprintf("%s %s is enabled on %s", p.Name, p.Version, e.IPAddress))
+				}
+			}
+		}
+
+		if len(rule.KeySizes) > 0 {
+			for _, chain := range d.CertChains {
+				if len(chain.CertIDs) == 0 || certs[chain.CertIDs[0]] == nil {
+					continue
+				}
+
+				leaf := certs[chain.CertIDs[0]]
+				minSize, allowed := rule.KeySizes[leaf.KeyAlg]
+				switch {
+				case !allowed:
+					violations = append(violations, fmt.Sprintf("%s key is not allowed on %s", leaf.KeyAlg, e.IPAddress))
+				case leaf.KeySize < minSize:
+					violations = append(violations, fmt.Sprintf("%s key of %d bits is below %d bits on %s", leaf.KeyAlg, leaf.KeySize, minSize, e.IPAddress))
+				}
+			}
+		}
+
+		if rule.MinHSTSMaxAge != nil {
+			hsts := d.HSTSPolicy
+			switch {
+			case hsts == nil || hsts.Status != "present":
+				violations = append(violations, fmt.Sprintf("HSTS is not enabled on %s", e.IPAddress))
+			case time.Duration(hsts.MaxAge)*time.Second < *rule.MinHSTSMaxAge:
+				violations = append(violations, fmt.Sprintf("HSTS max-age of %ds is below %.0fs on %s", hsts.MaxAge, rule.MinHSTSMaxAge.Seconds(), e.IPAddress))
+			}
+		}
+
+		if rule.OCSPStapling && !d.OCSPStapling {
+			violations = append(violations, fmt.Sprintf("OCSP stapling is not enabled on %s", e.IPAddress))
+		}
+
+		if rule.NoCBC && d.SupportsCBC {
+			violations = append(violations, fmt.Sprintf("CBC cipher suites are enabled on %s", e.IPAddress))
+		}
+	}
+
+	return violations
+}
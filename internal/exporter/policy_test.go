@@ -0,0 +1,159 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	ssllabsApi "github.com/essentialkaos/sslscan/v13"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/config"
+)
+
+// assessment of a single endpoint serving an RSA 2048 bits leaf certificate
+func policyInfo() *ssllabsApi.AnalyzeInfo {
+	return &ssllabsApi.AnalyzeInfo{
+		Endpoints: []*ssllabsApi.EndpointInfo{{
+			IPAddress: "192.0.2.1",
+			Grade:     "A",
+			Details: &ssllabsApi.EndpointDetails{
+				Protocols: []*ssllabsApi.Protocol{
+					{Name: "TLS", Version: "1.1", ID: 0x0302},
+					{Name: "TLS", Version: "1.2", ID: 0x0303},
+				},
+				CertChains:   []*ssllabsApi.ChainCert{{CertIDs: []string{"leaf", "intermediate"}}},
+				HSTSPolicy:   &ssllabsApi.HSTSPolicy{Status: "present", MaxAge: 86400},
+				OCSPStapling: false,
+				SupportsCBC:  true,
+			},
+		}, {
+			IPAddress:     "192.0.2.2",
+			StatusMessage: "Unable to connect to the server",
+		}},
+		Certs: []*ssllabsApi.Cert{
+			{ID: "leaf", KeyAlg: "RSA", KeySize: 2048},
+			{ID: "intermediate", KeyAlg: "RSA", KeySize: 4096},
+		},
+	}
+}
+
+func TestPolicyViolations(t *testing.T) {
+	day, year := 24*time.Hour, 365*24*time.Hour
+
+	var cases = []struct {
+		name               string
+		rule               config.PolicyRule
+		expectedViolations []string
+	}{
+		{
+			name:               "min_tls_version_met",
+			rule:               config.PolicyRule{MinTLSVersion: "1.1"},
+			expectedViolations: nil,
+		},
+		{
+			name:               "min_tls_version",
+			rule:               config.PolicyRule{MinTLSVersion: "1.2"},
+			expectedViolations: []string{"TLS 1.1 is enabled on 192.0.2.1"},
+		},
+		{
+			name:               "key_size_met",
+			rule:               config.PolicyRule{KeySizes: map[string]int{"RSA": 2048}},
+			expectedViolations: nil,
+		},
+		{
+			name:               "key_size",
+			rule:               config.PolicyRule{KeySizes: map[string]int{"RSA": 3072, "EC": 256}},
+			expectedViolations: []string{"RSA key of 2048 bits is below 3072 bits on 192.0.2.1"},
+		},
+		{
+			name:               "key_algorithm",
+			rule:               config.PolicyRule{KeySizes: map[string]int{"EC": 256}},
+			expectedViolations: []string{"RSA key is not allowed on 192.0.2.1"},
+		},
+		{
+			name:               "hsts_met",
+			rule:               config.PolicyRule{MinHSTSMaxAge: &day},
+			expectedViolations: nil,
+		},
+		{
+			name:               "hsts",
+			rule:               config.PolicyRule{MinHSTSMaxAge: &year},
+			expectedViolations: []string{"HSTS max-age of 86400s is below 31536000s on 192.0.2.1"},
+		},
+		{
+			name:               "ocsp_stapling_and_cbc",
+			rule:               config.PolicyRule{OCSPStapling: true, NoCBC: true},
+			expectedViolations: []string{"OCSP stapling is not enabled on 192.0.2.1", "CBC cipher suites are enabled on 192.0.2.1"},
+		},
+		{
+			name:               "min_grade",
+			rule:               config.PolicyRule{MinGrade: "A+"},
+			expectedViolations: []string{`grade "A" is below A+`},
+		},
+	}
+
+	for _, c := range cases {
+		if violations := policyViolations(&c.rule, policyInfo()); !reflect.DeepEqual(violations, c.expectedViolations) {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedViolations, violations)
+		}
+	}
+}
+
+func TestPoliciesResult(t *testing.T) {
+	SetPolicies([]config.PolicyRule{
+		{Name: "tls12", MinTLSVersion: "1.2"},
+		{Name: "graded", MinGrade: "B"},
+		{Name: "other_targets", Targets: config.TargetMatcher{Suffixes: []string{"example.org"}}, MinGrade: "A+"},
+	})
+	defer SetPolicies(nil)
+
+	result := NewResult("example.com", time.Now(), time.Second, policyInfo(), nil)
+
+	expected := []PolicyResult{
+		{Rule: "tls12", Passed: false, Violations: []string{"TLS 1.1 is enabled on 192.0.2.1"}},
+		{Rule: "graded", Passed: true},
+	}
+	if !reflect.DeepEqual(result.Policies, expected) {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "policies", expected, result.Policies)
+	}
+
+	metrics := `
+# HELP ssllabs_policy_passed Displays whether the target complies with all the policy rules applying to it
+# TYPE ssllabs_policy_passed gauge
+ssllabs_policy_passed{target="example.com"} 0
+# HELP ssllabs_policy_violation Displays whether the target violates the policy rule (value of 1) or not
+# TYPE ssllabs_policy_violation gauge
+ssllabs_policy_violation{rule="graded",target="example.com"} 0
+ssllabs_policy_violation{rule="tls12",target="example.com"} 1
+`
+	if err := testutil.GatherAndCompare(result.Registry, strings.NewReader(metrics), "ssllabs_policy_passed", "ssllabs_policy_violation"); err != nil {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "policies_metrics", nil, err)
+	}
+
+	report := NewReport(result)
+	if len(report.Policies) != 2 || report.PoliciesPassed == nil || *report.PoliciesPassed {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "policies_report", expected, report.Policies)
+	}
+
+	// failed assessments are not evaluated
+	if failed := NewResult("example.com", time.Now(), time.Second, nil, errors.New("connection refused")); len(failed.Policies) != 0 {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "failed_assessment", nil, failed.Policies)
+	}
+}
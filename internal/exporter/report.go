@@ -0,0 +1,130 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"time"
+
+	ssllabsApi "github.com/essentialkaos/sslscan/v13"
+)
+
+// Report is a summary of the assessment results meant to be JSON encoded
+type Report struct {
+	Target         string              `json:"target"`
+	Labels         map[string]string   `json:"labels,omitempty"`
+	Success        bool                `json:"success"`
+	FailureReason  string              `json:"failure_reason,omitempty"`
+	Error          string              `json:"error,omitempty"`
+	Grade          string              `json:"grade,omitempty"`
+	ProbeTime      time.Time           `json:"probe_time"`
+	AssessmentTime *time.Time          `json:"assessment_time,omitempty"`
+	Endpoints      []EndpointReport    `json:"endpoints"`
+	Certificates   []CertificateReport `json:"certificates"`
+	Policies       []PolicyResult      `json:"policies,omitempty"`
+	PoliciesPassed *bool               `json:"policies_passed,omitempty"`
+}
+
+// EndpointReport is a summary of the assessment of one of the target endpoints
+type EndpointReport struct {
+	IPAddress     string `json:"ip_address"`
+	ServerName    string `json:"server_name,omitempty"`
+	Grade         string `json:"grade,omitempty"`
+	StatusMessage string `json:"status_message,omitempty"`
+	HasWarnings   bool   `json:"has_warnings"`
+	IsExceptional bool   `json:"is_exceptional"`
+}
+
+// CertificateReport is a summary of one of the certificates served by the target
+type CertificateReport struct {
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	SerialNumber       string    `json:"serial_number"`
+	AltNames           []string  `json:"alt_names,omitempty"`
+	KeyAlgorithm       string    `json:"key_algorithm"`
+	KeySize            int       `json:"key_size"`
+	SignatureAlgorithm string    `json:"signature_algorithm"`
+	NotBefore          time.Time `json:"not_before"`
+	NotAfter           time.Time `json:"not_after"`
+}
+
+// NewReport summarizes the assessment results
+func NewReport(result *Result) *Report {
+	report := &Report{
+		Target:       result.Target,
+		Labels:       result.Labels,
+		Success:      result.Err == nil,
+		ProbeTime:    result.Start,
+		Endpoints:    []EndpointReport{},
+		Certificates: []CertificateReport{},
+	}
+
+	if result.Err != nil {
+		report.FailureReason = failureReason(result.Err)
+		report.Error = result.Err.Error()
+		return report
+	}
+
+	if result.Info == nil {
+		return report
+	}
+
+	report.Grade = endpointsLowestGrade(result.Info.Endpoints)
+
+	if result.Info.TestTime > 0 {
+		assessmentTime := time.UnixMilli(result.Info.TestTime)
+		report.AssessmentTime = &assessmentTime
+	}
+
+	for _, e := range result.Info.Endpoints {
+		report.Endpoints = append(report.Endpoints, newEndpointReport(e))
+	}
+
+	for _, c := range result.Info.Certs {
+		report.Certificates = append(report.Certificates, newCertificateReport(c))
+	}
+
+	if len(result.Policies) > 0 {
+		passed := PoliciesPassed(result.Policies)
+		report.Policies = result.Policies
+		report.PoliciesPassed = &passed
+	}
+
+	return report
+}
+
+func newEndpointReport(e *ssllabsApi.EndpointInfo) EndpointReport {
+	return EndpointReport{
+		IPAddress:     e.IPAddress,
+		ServerName:    e.ServerName,
+		Grade:         e.Grade,
+		StatusMessage: e.StatusMessage,
+		HasWarnings:   e.HasWarnings,
+		IsExceptional: e.IsExceptional,
+	}
+}
+
+func newCertificateReport(c *ssllabsApi.Cert) CertificateReport {
+	return CertificateReport{
+		Subject:            c.Subject,
+		Issuer:             c.IssuerSubject,
+		SerialNumber:       c.SerialNumber,
+		AltNames:           c.AltNames,
+		KeyAlgorithm:       c.KeyAlg,
+		KeySize:            c.KeySize,
+		SignatureAlgorithm: c.SigAlg,
+		NotBefore:          time.UnixMilli(c.NotBefore),
+		NotAfter:           time.UnixMilli(c.NotAfter),
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ssllabsApi "github.com/essentialkaos/sslscan/v13"
+)
+
+func TestNewReport(t *testing.T) {
+	failed := NewReport(Interrupted("prometheus.io", time.Now(), context.DeadlineExceeded))
+	if failed.Success || failed.FailureReason != "timeout" || failed.Grade != "" {
+		t.Errorf("Unexpected report for a failed assessment : %+v", failed)
+	}
+
+	testTime := time.Now().Truncate(time.Millisecond)
+	succeeded := NewReport(&Result{
+		Target: "prometheus.io",
+		Info: &ssllabsApi.AnalyzeInfo{
+			TestTime: testTime.UnixMilli(),
+			Endpoints: []*ssllabsApi.EndpointInfo{
+				{IPAddress: "192.0.2.1", Grade: "A+"},
+				{IPAddress: "192.0.2.2", Grade: "B"},
+			},
+			Certs: []*ssllabsApi.Cert{
+				{SerialNumber: "01", NotAfter: testTime.UnixMilli()},
+			},
+		},
+	})
+
+	switch {
+	case !succeeded.Success:
+		t.Errorf("Successful assessment reported as failed")
+	case succeeded.Grade != "B":
+		t.Errorf("Unexpected grade.\nExpected : %v\nGot : %v\n", "B", succeeded.Grade)
+	case len(succeeded.Endpoints) != 2 || len(succeeded.Certificates) != 1:
+		t.Errorf("Unexpected endpoints or certificates : %+v", succeeded)
+	case !succeeded.AssessmentTime.Equal(testTime) || !succeeded.Certificates[0].NotAfter.Equal(testTime):
+		t.Errorf("Unexpected timestamps : %+v", succeeded)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	ssllabsApi "github.com/essentialkaos/sslscan/v13"
+)
+
+// vulnerabilities checks of the endpoint details as documented in
+// https://github.com/ssllabs/ssllabs-scan/blob/master/ssllabs-api-docs-v3.md#endpointdetails
+var vulnerabilityChecks = []struct {
+	name       string
+	vulnerable func(d *ssllabsApi.EndpointDetails) bool
+}{
+	{"beast", func(d *ssllabsApi.EndpointDetails) bool { return d.VulnBeast }},
+	{"drown", func(d *ssllabsApi.EndpointDetails) bool { return d.DrownVulnerable }},
+	{"freak", func(d *ssllabsApi.EndpointDetails) bool { return d.Freak }},
+	{"golden_doodle", func(d *ssllabsApi.EndpointDetails) bool { return d.GoldenDoodle == 4 || d.GoldenDoodle == 5 }},
+	{"heartbleed", func(d *ssllabsApi.EndpointDetails) bool { return d.Heartbleed }},
+	{"logjam", func(d *ssllabsApi.EndpointDetails) bool { return d.Logjam }},
+	{"openssl_ccs", func(d *ssllabsApi.EndpointDetails) bool { return d.OpenSSLCCS == 3 }},
+	{"openssl_lucky_minus20", func(d *ssllabsApi.EndpointDetails) bool { return d.OpenSSLLuckyMinus20 == 2 }},
+	{"poodle", func(d *ssllabsApi.EndpointDetails) bool { return d.Poodle }},
+	{"poodle_tls", func(d *ssllabsApi.EndpointDetails) bool { return d.PoodleTLS == 2 }},
+	{"robot", func(d *ssllabsApi.EndpointDetails) bool { return d.Bleichenbacher == 2 || d.Bleichenbacher == 3 }},
+	{"sleeping_poodle", func(d *ssllabsApi.EndpointDetails) bool { return d.SleepingPoodle == 10 || d.SleepingPoodle == 11 }},
+	{"ticketbleed", func(d *ssllabsApi.EndpointDetails) bool { return d.Ticketbleed == 2 }},
+	{"zero_length_padding_oracle", func(d *ssllabsApi.EndpointDetails) bool {
+		return d.ZeroLengthPaddingOracle == 6 || d.ZeroLengthPaddingOracle == 7
+	}},
+	{"zombie_poodle", func(d *ssllabsApi.EndpointDetails) bool { return d.ZombiePoodle == 2 || d.ZombiePoodle == 3 }},
+}
+
+// Vulnerabilities lists the names of the vulnerabilities SSLLabs found on the endpoint, sorted by name
+func Vulnerabilities(details *ssllabsApi.EndpointDetails) []string {
+	vulnerabilities := []string{}
+	if details == nil {
+		return vulnerabilities
+	}
+
+	for _, check := range vulnerabilityChecks {
+		if check.vulnerable(details) {
+			vulnerabilities = append(vulnerabilities, check.name)
+		}
+	}
+
+	return vulnerabilities
+}
+
+// VulnerabilityNames lists the names of the vulnerabilities checked by Vulnerabilities
+func VulnerabilityNames() []string {
+	names := make([]string, 0, len(vulnerabilityChecks))
+	for _, check := range vulnerabilityChecks {
+		names = append(names, check.name)
+	}
+
+	return names
+}
@@ -0,0 +1,52 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exporter
+
+import (
+	"reflect"
+	"testing"
+
+	ssllabsApi "github.com/essentialkaos/sslscan/v13"
+)
+
+func TestVulnerabilities(t *testing.T) {
+	var cases = []struct {
+		name     string
+		details  *ssllabsApi.EndpointDetails
+		expected []string
+	}{
+		{
+			name:     "missing_details",
+			details:  nil,
+			expected: []string{},
+		},
+		{
+			name:     "not_vulnerable",
+			details:  &ssllabsApi.EndpointDetails{OpenSSLCCS: 1, Bleichenbacher: 1, ZombiePoodle: 1, PoodleTLS: 1},
+			expected: []string{},
+		},
+		{
+			name:     "vulnerable",
+			details:  &ssllabsApi.EndpointDetails{Heartbleed: true, OpenSSLCCS: 3, Bleichenbacher: 2, GoldenDoodle: 4},
+			expected: []string{"golden_doodle", "heartbleed", "openssl_ccs", "robot"},
+		},
+	}
+
+	for _, c := range cases {
+		if got := Vulnerabilities(c.details); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expected, got)
+		}
+	}
+}
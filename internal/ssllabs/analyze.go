@@ -16,48 +16,130 @@ package ssllabs
 
 import (
 	"context"
-	"fmt"
 	"math/rand"
+	"sync"
 	"time"
 
 	ssllabsApi "github.com/essentialkaos/sslscan/v13"
 	log "github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/anas-aso/ssllabs_exporter/internal/build"
 )
 
-var api *ssllabsApi.API
+var tracer = otel.Tracer("github.com/anas-aso/ssllabs_exporter/internal/ssllabs")
+
+var (
+	apiMu sync.Mutex
+	api   *ssllabsApi.API
+)
+
+var (
+	// PollInterval is the minimum delay between two assessment updates requests
+	PollInterval = 10 * time.Second
+	// PollJitter is the maximum random delay added to PollInterval
+	PollJitter = 10 * time.Second
+)
+
+// how old SSLLabs cached results can be to be used when the context has no deadline
+const defaultMaxResultAge = 10 * time.Minute
+
+type startNewKey struct{}
+
+// WithStartNew returns a context for which Analyze ignores SSLLabs cached
+// results and triggers a new assessment, unless one is already in progress
+func WithStartNew(ctx context.Context) context.Context {
+	return context.WithValue(ctx, startNewKey{}, true)
+}
+
+type progressKey struct{}
+
+// WithProgress returns a context for which Analyze reports the assessment
+// status each time it is fetched from SSLLabs
+func WithProgress(ctx context.Context, report func(*ssllabsApi.AnalyzeInfo)) context.Context {
+	return context.WithValue(ctx, progressKey{}, report)
+}
+
+// return the API client, initializing it on first use since
+// it requires a successful call to the SSLLabs API
+func client() (*ssllabsApi.API, error) {
+	apiMu.Lock()
+	defer apiMu.Unlock()
 
-func init() {
-	api, _ = ssllabsApi.NewAPI("ssllabs-exporter", build.Version)
 	if api == nil {
-		panic("failed to initialize API client. this should never happen!")
+		// the client fetches the API info on creation
+		start := time.Now()
+		c, err := ssllabsApi.NewAPI("ssllabs-exporter", build.Version)
+		recordRequest(endpointInfo, start, statusCode(err))
+		if err != nil {
+			return nil, err
+		}
+		api = c
 	}
+
+	return api, nil
 }
 
-// Analyze executes the SSL test HTTP requests
+// Analyze executes the SSL test HTTP requests.
+// The returned error is an *Error describing the failure cause.
 func Analyze(ctx context.Context, logger log.Logger, target string) (result *ssllabsApi.AnalyzeInfo, err error) {
+	assessmentsInFlight.Inc()
+	defer assessmentsInFlight.Dec()
+
+	ctx, span := tracer.Start(ctx, "ssllabs.Analyze", trace.WithAttributes(attribute.String("target", target)))
+	defer span.End()
+
+	result, err = analyze(ctx, logger, target)
+	err = classify(err)
+
+	if result != nil {
+		span.SetAttributes(attribute.String("status", result.Status))
+	}
+	if err != nil {
+		span.SetAttributes(attribute.String("status", ErrorStatus(err)))
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return result, err
+}
+
+func analyze(ctx context.Context, logger log.Logger, target string) (result *ssllabsApi.AnalyzeInfo, err error) {
 	logger.Debug().Str("target", target).Msg("start processing")
 
+	polls := 0
+	defer func() { assessmentPolls.Observe(float64(polls)) }()
+
+	api, err := client()
+	if err != nil {
+		logger.Error().Err(err).Str("target", target).Msg("failed to initialize API client")
+		return
+	}
+
 	// check cached results and return them if they are "fresh enough"
 	// this is mainly useful if the previous context timed out or
 	// canceled before we collected the results
-	analyzeProgress, err := api.Analyze(target, ssllabsApi.AnalyzeParams{})
+	analyzeProgress, err := analyzeRequest(ctx, api, target, ssllabsApi.AnalyzeParams{})
 	if err != nil {
 		logger.Error().Err(err).Str("target", target).Msg("failed to get cached result")
 		return
 	}
 
-	result, err = analyzeProgress.Info(true, false)
+	result, err = infoRequest(ctx, analyzeProgress)
 	if err != nil {
 		logger.Error().Err(err).Str("target", target).Msg("failed to get cached result")
 		return
 	}
 
-	deadline, _ := ctx.Deadline()
 	// reconstruct the assessment timeout from the context deadline
-	timeout := deadline.Unix() - time.Now().Unix()
-	if result.Status == ssllabsApi.STATUS_READY && result.TestTime/1000+timeout >= time.Now().Unix() {
+	maxResultAge := defaultMaxResultAge
+	if deadline, ok := ctx.Deadline(); ok {
+		maxResultAge = time.Until(deadline)
+	}
+	startNew, _ := ctx.Value(startNewKey{}).(bool)
+	if !startNew && result.Status == ssllabsApi.STATUS_READY && time.UnixMilli(result.TestTime).Add(maxResultAge).After(time.Now()) {
 		logger.Debug().Str("target", target).Msg("cached result will be used")
 		return
 	}
@@ -65,36 +147,67 @@ func Analyze(ctx context.Context, logger log.Logger, target string) (result *ssl
 	// trigger a new assessment if there isn't one in progress
 	if result.Status != ssllabsApi.STATUS_DNS && result.Status != ssllabsApi.STATUS_IN_PROGRESS {
 		logger.Debug().Str("target", target).Msg("triggering a new assessment")
-		analyzeProgress, err = api.Analyze(target, ssllabsApi.AnalyzeParams{StartNew: true})
+		analyzeProgress, err = analyzeRequest(ctx, api, target, ssllabsApi.AnalyzeParams{StartNew: true})
 		if err != nil {
 			logger.Error().Err(err).Str("target", target).Msg("failed to trigger a new assessment")
 			return
 		}
 	}
 
-	result, err = analyzeProgress.Info(true, false)
+	result, err = infoRequest(ctx, analyzeProgress)
 	if err != nil {
 		logger.Error().Err(err).Str("target", target).Msg("failed to get running assessment info")
 		return
 	}
 
+	report, _ := ctx.Value(progressKey{}).(func(*ssllabsApi.AnalyzeInfo))
+
 	for {
-		switch {
-		case result.Status == ssllabsApi.STATUS_READY:
+		if report != nil {
+			report(result)
+		}
+
+		progress, eta := assessmentProgress(result)
+		logger.Debug().Str("target", target).Str("status", result.Status).Int("progress", progress).Int("eta_seconds", eta).Msg("assessment status")
+
+		switch result.Status {
+		case ssllabsApi.STATUS_READY:
 			logger.Debug().Str("target", target).Msg("assessment finished successfully")
 			return result, nil
-		case time.Now().After(deadline):
-			result.Status = StatusDeadlineExceeded
-			return result, fmt.Errorf("context deadline exceeded")
+		case ssllabsApi.STATUS_ERROR:
+			logger.Debug().Str("target", target).Str("message", result.StatusMessage).Msg("assessment failed")
+			return result, assessmentError(result.StatusMessage)
+		}
+
 		// fetch updates at random intervals
-		default:
-			time.Sleep(time.Duration(10+rand.Intn(10)) * time.Second)
-			logger.Debug().Str("target", target).Msg("fetching assessment updates")
-			result, err = analyzeProgress.Info(true, false)
-			if err != nil {
-				logger.Error().Err(err).Str("target", target).Msg("failed to fetch updates")
-				return
-			}
+		_, wait := tracer.Start(ctx, "ssllabs.poll_wait", trace.WithAttributes(attribute.String("status", result.Status), attribute.Int("progress", progress)))
+		timer := time.NewTimer(pollDelay())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			wait.End()
+			err = classify(ctx.Err())
+			result.Status = ErrorStatus(err)
+			return result, err
+		case <-timer.C:
+		}
+		wait.End()
+
+		logger.Debug().Str("target", target).Msg("fetching assessment updates")
+		polls++
+		result, err = infoRequest(ctx, analyzeProgress)
+		if err != nil {
+			logger.Error().Err(err).Str("target", target).Msg("failed to fetch updates")
+			return
 		}
 	}
 }
+
+// delay before the next assessment updates request
+func pollDelay() time.Duration {
+	if PollJitter <= 0 {
+		return PollInterval
+	}
+
+	return PollInterval + time.Duration(rand.Int63n(int64(PollJitter)))
+}
@@ -0,0 +1,55 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssllabs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPollDelay(t *testing.T) {
+	defer func(interval, jitter time.Duration) {
+		PollInterval, PollJitter = interval, jitter
+	}(PollInterval, PollJitter)
+
+	var cases = []struct {
+		name     string
+		interval time.Duration
+		jitter   time.Duration
+	}{
+		{
+			name:     "without_jitter",
+			interval: 5 * time.Second,
+			jitter:   0,
+		},
+		{
+			name:     "with_jitter",
+			interval: 5 * time.Second,
+			jitter:   time.Second,
+		},
+	}
+
+	for _, c := range cases {
+		PollInterval, PollJitter = c.interval, c.jitter
+
+		for i := 0; i < 100; i++ {
+			delay := pollDelay()
+			if delay < c.interval || delay > c.interval+c.jitter {
+				t.Errorf("Test case : %v failed.\nExpected : [%v, %v]\nGot : %v\n", c.name, c.interval, c.interval+c.jitter, delay)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssllabs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	ssllabsApi "github.com/essentialkaos/sslscan/v13"
+)
+
+// HTTP status code used by SSLLabs API when the service is overloaded
+const statusOverloaded = 529
+
+// Error is returned when an assessment could not be completed
+type Error struct {
+	// Status describes the failure cause using one of the Status* constants
+	Status string
+
+	err error
+}
+
+func (e *Error) Error() string {
+	return e.err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// NewError creates an error with the provided status, such as
+// the ones restored from a shared cache
+func NewError(status, message string) error {
+	return &Error{Status: status, err: errors.New(message)}
+}
+
+// ErrorStatus returns the status describing why an assessment failed
+func ErrorStatus(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var e *Error
+	errors.As(classify(err), &e)
+
+	return e.Status
+}
+
+// wrap the error returned by the API client with the matching status
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+
+	var httpErr *ssllabsApi.HTTPError
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Error{Status: StatusDeadlineExceeded, err: err}
+	case errors.Is(err, context.Canceled):
+		return &Error{Status: StatusAborted, err: err}
+	case errors.As(err, &httpErr):
+		switch {
+		case httpErr.StatusCode == http.StatusTooManyRequests,
+			httpErr.StatusCode == http.StatusServiceUnavailable,
+			httpErr.StatusCode == statusOverloaded:
+			return &Error{Status: StatusRateLimited, err: err}
+		case httpErr.StatusCode >= http.StatusInternalServerError:
+			return &Error{Status: StatusServerError, err: err}
+		}
+	}
+
+	return &Error{Status: StatusHTTPError, err: err}
+}
+
+// convert a failed assessment status message to an error
+func assessmentError(message string) error {
+	// SSLLabs doesn't provide an error code, only a human readable message
+	// such as "Unable to resolve domain name"
+	if strings.Contains(strings.ToLower(message), "resolve domain name") {
+		return &Error{Status: StatusDNSError, err: errors.New(message)}
+	}
+
+	return &Error{Status: StatusError, err: errors.New(message)}
+}
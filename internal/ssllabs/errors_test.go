@@ -0,0 +1,56 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssllabs
+
+import (
+	"context"
+	"testing"
+)
+
+func TestErrorStatus(t *testing.T) {
+	var cases = []struct {
+		name           string
+		err            error
+		expectedResult string
+	}{
+		{
+			name:           "no_error",
+			err:            nil,
+			expectedResult: "",
+		},
+		{
+			name:           "unresolvable_host",
+			err:            assessmentError("Unable to resolve domain name"),
+			expectedResult: StatusDNSError,
+		},
+		{
+			name:           "assessment_error",
+			err:            assessmentError("Unable to connect to the server"),
+			expectedResult: StatusError,
+		},
+		{
+			name:           "already_classified",
+			err:            classify(&Error{Status: StatusAborted, err: context.Canceled}),
+			expectedResult: StatusAborted,
+		},
+	}
+
+	for _, c := range cases {
+		result := ErrorStatus(c.err)
+		if result != c.expectedResult {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedResult, result)
+		}
+	}
+}
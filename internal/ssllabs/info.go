@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -33,10 +34,13 @@ type APIInfo struct {
 func Info() (info APIInfo, err error) {
 	// TODO: make http timeout configurable
 	httpClient := http.Client{Timeout: 1 * time.Minute}
+	start := time.Now()
 	response, err := httpClient.Get(API + "/info")
 	if err != nil {
+		recordRequest(endpointInfo, start, codeError)
 		return
 	}
+	recordRequest(endpointInfo, start, strconv.Itoa(response.StatusCode))
 
 	defer response.Body.Close()
 
@@ -0,0 +1,123 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssllabs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	ssllabsApi "github.com/essentialkaos/sslscan/v13"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SSLLabs API endpoints label values
+const (
+	endpointInfo    = "info"
+	endpointAnalyze = "analyze"
+)
+
+// status code label value of the requests without a valid response
+const codeError = "error"
+
+var (
+	apiRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ssllabs_exporter_api_requests_total",
+			Help: "Number of SSLLabs API requests by endpoint and HTTP status code",
+		},
+		[]string{"endpoint", "code"},
+	)
+	apiRequestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "ssllabs_exporter_api_request_duration_seconds",
+			Help:    "Time spent waiting on the SSLLabs API responses by endpoint",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"endpoint"},
+	)
+	assessmentPolls = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "ssllabs_exporter_assessment_polls",
+			Help:    "Number of assessment updates requests per assessment",
+			Buckets: []float64{0, 1, 2, 5, 10, 20, 50, 100},
+		},
+	)
+	assessmentsInFlight = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "ssllabs_exporter_assessments_in_flight",
+			Help: "Number of assessments in progress",
+		},
+	)
+)
+
+// record a request sent at the start time
+func recordRequest(endpoint string, start time.Time, code string) {
+	apiRequestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	apiRequests.WithLabelValues(endpoint, code).Inc()
+}
+
+// status code of a request sent by the API client
+func statusCode(err error) string {
+	if err == nil {
+		return strconv.Itoa(http.StatusOK)
+	}
+
+	var httpErr *ssllabsApi.HTTPError
+	if errors.As(err, &httpErr) {
+		return strconv.Itoa(httpErr.StatusCode)
+	}
+
+	return codeError
+}
+
+// start a span for an API request and return a function recording its outcome
+func traceRequest(ctx context.Context, name string, attrs ...attribute.KeyValue) func(err error) {
+	start := time.Now()
+	_, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+
+	return func(err error) {
+		code := statusCode(err)
+		recordRequest(endpointAnalyze, start, code)
+
+		span.SetAttributes(attribute.String("http.status_code", code))
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// start or fetch an assessment
+func analyzeRequest(ctx context.Context, api *ssllabsApi.API, target string, params ssllabsApi.AnalyzeParams) (progress *ssllabsApi.AnalyzeProgress, err error) {
+	done := traceRequest(ctx, "ssllabs.api.Analyze", attribute.String("target", target), attribute.Bool("start_new", params.StartNew))
+	defer func() { done(err) }()
+
+	return api.Analyze(target, params)
+}
+
+// fetch the assessment details
+func infoRequest(ctx context.Context, progress *ssllabsApi.AnalyzeProgress) (info *ssllabsApi.AnalyzeInfo, err error) {
+	done := traceRequest(ctx, "ssllabs.api.Info")
+	defer func() { done(err) }()
+
+	return progress.Info(true, false)
+}
@@ -0,0 +1,55 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssllabs
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	ssllabsApi "github.com/essentialkaos/sslscan/v13"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestStatusCode(t *testing.T) {
+	var cases = []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{name: "success", err: nil, expected: "200"},
+		{name: "http_error", err: &ssllabsApi.HTTPError{StatusCode: 529}, expected: "529"},
+		{name: "wrapped_http_error", err: fmt.Errorf("request: %w", &ssllabsApi.HTTPError{StatusCode: 429}), expected: "429"},
+		{name: "network_error", err: errors.New("connection refused"), expected: codeError},
+	}
+
+	for _, c := range cases {
+		code := statusCode(c.err)
+		if code != c.expected {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expected, code)
+		}
+	}
+}
+
+func TestRecordRequest(t *testing.T) {
+	before := testutil.ToFloat64(apiRequests.WithLabelValues(endpointAnalyze, "429"))
+
+	recordRequest(endpointAnalyze, time.Now(), "429")
+
+	if got := testutil.ToFloat64(apiRequests.WithLabelValues(endpointAnalyze, "429")); got != before+1 {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "record_request", before+1, got)
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssllabs
+
+import (
+	ssllabsApi "github.com/essentialkaos/sslscan/v13"
+)
+
+// summarize the progress of an assessment from its endpoints, which SSLLabs
+// assesses one after the other. The progress is a percentage and the ETA is
+// the estimated number of seconds until the assessment finishes.
+func assessmentProgress(info *ssllabsApi.AnalyzeInfo) (progress, eta int) {
+	if info == nil || len(info.Endpoints) == 0 {
+		return 0, 0
+	}
+
+	for _, e := range info.Endpoints {
+		// endpoints not started yet have a progress of -1
+		if e.Progress > 0 {
+			progress += e.Progress
+		}
+
+		if e.ETA > 0 {
+			eta += e.ETA
+		}
+	}
+
+	return progress / len(info.Endpoints), eta
+}
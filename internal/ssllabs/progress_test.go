@@ -0,0 +1,58 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssllabs
+
+import (
+	"testing"
+
+	ssllabsApi "github.com/essentialkaos/sslscan/v13"
+)
+
+func TestAssessmentProgress(t *testing.T) {
+	var cases = []struct {
+		name             string
+		info             *ssllabsApi.AnalyzeInfo
+		expectedProgress int
+		expectedETA      int
+	}{
+		{
+			name: "no_endpoints",
+			info: &ssllabsApi.AnalyzeInfo{},
+		},
+		{
+			name: "not_started",
+			info: &ssllabsApi.AnalyzeInfo{Endpoints: []*ssllabsApi.EndpointInfo{
+				{Progress: -1, ETA: -1},
+			}},
+		},
+		{
+			name: "second_endpoint_in_progress",
+			info: &ssllabsApi.AnalyzeInfo{Endpoints: []*ssllabsApi.EndpointInfo{
+				{Progress: 100},
+				{Progress: 50, ETA: 30},
+				{Progress: -1, ETA: 60},
+			}},
+			expectedProgress: 50,
+			expectedETA:      90,
+		},
+	}
+
+	for _, c := range cases {
+		progress, eta := assessmentProgress(c.info)
+		if progress != c.expectedProgress || eta != c.expectedETA {
+			t.Errorf("Test case : %v failed.\nExpected : %v %v\nGot : %v %v\n", c.name, c.expectedProgress, c.expectedETA, progress, eta)
+		}
+	}
+}
@@ -27,8 +27,12 @@ const (
 	StatusReady = "READY"
 	// StatusHTTPError error processing the HTTP response from SSLLabs API
 	StatusHTTPError = "HTTP_ERROR"
-	// StatusServerError SSLLabs API server error or rate limiting
+	// StatusServerError SSLLabs API server error
 	StatusServerError = "SERVER_ERROR"
+	// StatusRateLimited SSLLabs API rate limiting or service overloaded
+	StatusRateLimited = "RATE_LIMITED"
+	// StatusDNSError SSLLabs could not resolve the target host name
+	StatusDNSError = "DNS_ERROR"
 	// StatusDeadlineExceeded assessment deadline exceeded
 	StatusDeadlineExceeded = "DEADLINE_EXCEEDED"
 	// StatusAborted assessment canceled by the client
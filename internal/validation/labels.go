@@ -0,0 +1,51 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validation
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	// ReasonInvalidLabel the label name can't be used as a Prometheus label
+	ReasonInvalidLabel = "invalid_label"
+	// ReasonLabelConflict the label is already set with another value
+	ReasonLabelConflict = "label_conflict"
+)
+
+// valid Prometheus label names
+var labelNameRE = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+// labels of the assessment metrics, which the target labels can't override
+var metricLabels = []string{"grade", "reason", "rule", "target"}
+
+// Labels checks that the target labels can be added to the assessment metrics.
+// The names starting with __ are reserved for Prometheus internal use.
+func Labels(labels map[string]string) error {
+	for name := range labels {
+		switch {
+		case !labelNameRE.MatchString(name):
+			return &Error{Label: name, Reason: ReasonInvalidLabel, Message: "invalid label name"}
+		case strings.HasPrefix(name, "__"):
+			return &Error{Label: name, Reason: ReasonInvalidLabel, Message: "reserved label name"}
+		case slices.Contains(metricLabels, name):
+			return &Error{Label: name, Reason: ReasonLabelConflict, Message: "label name used by the assessment metrics"}
+		}
+	}
+
+	return nil
+}
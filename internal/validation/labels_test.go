@@ -0,0 +1,39 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validation
+
+import (
+	"testing"
+)
+
+func TestLabels(t *testing.T) {
+	var cases = []struct {
+		name          string
+		labels        map[string]string
+		expectedError bool
+	}{
+		{name: "valid", labels: map[string]string{"team": "web", "_owner2": "alice"}, expectedError: false},
+		{name: "invalid_character", labels: map[string]string{"team.name": "web"}, expectedError: true},
+		{name: "leading_digit", labels: map[string]string{"1team": "web"}, expectedError: true},
+		{name: "reserved", labels: map[string]string{"__param_module": "daily"}, expectedError: true},
+		{name: "metric_label", labels: map[string]string{"grade": "A"}, expectedError: true},
+	}
+
+	for _, c := range cases {
+		if err := Labels(c.labels); (err != nil) != c.expectedError {
+			t.Errorf("Test case : %v failed.\nExpected error : %v\nGot : %v\n", c.name, c.expectedError, err)
+		}
+	}
+}
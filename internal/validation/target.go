@@ -0,0 +1,132 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validation
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+const (
+	// ReasonMissing the target is empty
+	ReasonMissing = "missing_target"
+	// ReasonInvalid the target is not a valid host name
+	ReasonInvalid = "invalid_target"
+	// ReasonIPAddress the target is an IP address which SSLLabs can't assess
+	ReasonIPAddress = "ip_address"
+	// ReasonReserved the target is a private or reserved name not reachable by SSLLabs
+	ReasonReserved = "reserved_name"
+	// ReasonDenied the target is not allowed by the exporter configuration
+	ReasonDenied = "denied_target"
+)
+
+// SSLLabs only assesses the HTTPS default port
+const httpsPort = "443"
+
+// host names profile checking the DNS length limits and the empty labels
+var hostProfile = idna.New(idna.MapForLookup(), idna.VerifyDNSLength(true), idna.BidiRule(), idna.StrictDomainName(true))
+
+// special use and private top level domains which can't be resolved on the public Internet
+// RFC 2606, RFC 6761, RFC 6762, RFC 7686, RFC 8375 and RFC 9476
+var reservedSuffixes = []string{
+	"alt",
+	"example",
+	"home.arpa",
+	"internal",
+	"invalid",
+	"local",
+	"localdomain",
+	"localhost",
+	"onion",
+	"test",
+}
+
+// Error describes why a target or one of its labels was rejected
+type Error struct {
+	Target  string `json:"target,omitempty"`
+	Label   string `json:"label,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Label != "" {
+		return fmt.Sprintf("%s: %s", e.Message, e.Label)
+	}
+
+	return fmt.Sprintf("%s: %s", e.Message, e.Target)
+}
+
+// Target validates the target and returns its normalized host name which can
+// be assessed by SSLLabs. URLs and host:port are reduced to their host name.
+func Target(raw string) (string, error) {
+	host := strings.TrimSpace(raw)
+	if host == "" {
+		return "", &Error{Target: raw, Reason: ReasonMissing, Message: "target parameter is missing"}
+	}
+
+	// strip the scheme, user info, path, query and fragment
+	if strings.Contains(host, "://") {
+		u, err := url.Parse(host)
+		if err != nil {
+			return "", &Error{Target: raw, Reason: ReasonInvalid, Message: "target is not a valid URL"}
+		}
+		host = u.Host
+	} else {
+		if i := strings.IndexAny(host, "/?#"); i >= 0 {
+			host = host[:i]
+		}
+		if i := strings.LastIndex(host, "@"); i >= 0 {
+			host = host[i+1:]
+		}
+	}
+
+	// strip the port
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if port != httpsPort {
+			return "", &Error{Target: raw, Reason: ReasonInvalid, Message: "only the HTTPS default port can be assessed"}
+		}
+		host = h
+	}
+
+	host = strings.TrimSuffix(host, ".")
+
+	if net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return "", &Error{Target: raw, Reason: ReasonIPAddress, Message: "IP addresses can't be assessed"}
+	}
+
+	// convert internationalized names to punycode and lowercase them
+	host, err := hostProfile.ToASCII(host)
+	if err != nil || host == "" {
+		return "", &Error{Target: raw, Reason: ReasonInvalid, Message: "target is not a valid host name"}
+	}
+
+	// single label names are never resolvable on the public Internet
+	if !strings.Contains(host, ".") {
+		return "", &Error{Target: raw, Reason: ReasonReserved, Message: "target is not a fully qualified domain name"}
+	}
+
+	for _, suffix := range reservedSuffixes {
+		if host == suffix || strings.HasSuffix(host, "."+suffix) {
+			return "", &Error{Target: raw, Reason: ReasonReserved, Message: "target belongs to a reserved domain"}
+		}
+	}
+
+	return host, nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validation
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTarget(t *testing.T) {
+	var cases = []struct {
+		name           string
+		target         string
+		expectedResult string
+		expectedReason string
+	}{
+		{
+			name:           "host_name",
+			target:         "prometheus.io",
+			expectedResult: "prometheus.io",
+		},
+		{
+			name:           "uppercase_with_trailing_dot",
+			target:         "Prometheus.IO.",
+			expectedResult: "prometheus.io",
+		},
+		{
+			name:           "url",
+			target:         "https://user@prometheus.io:443/docs?q=1#top",
+			expectedResult: "prometheus.io",
+		},
+		{
+			name:           "host_and_path",
+			target:         "prometheus.io/docs",
+			expectedResult: "prometheus.io",
+		},
+		{
+			name:           "host_and_port",
+			target:         "prometheus.io:443",
+			expectedResult: "prometheus.io",
+		},
+		{
+			name:           "internationalized_name",
+			target:         "Bücher.example.com",
+			expectedResult: "xn--bcher-kva.example.com",
+		},
+		{
+			name:           "missing",
+			target:         " ",
+			expectedReason: ReasonMissing,
+		},
+		{
+			name:           "non_https_port",
+			target:         "prometheus.io:8443",
+			expectedReason: ReasonInvalid,
+		},
+		{
+			name:           "invalid_characters",
+			target:         "prome_theus.io",
+			expectedReason: ReasonInvalid,
+		},
+		{
+			name:           "empty_label",
+			target:         "a..b.com",
+			expectedReason: ReasonInvalid,
+		},
+		{
+			name:           "label_too_long",
+			target:         strings.Repeat("a", 64) + ".com",
+			expectedReason: ReasonInvalid,
+		},
+		{
+			name:           "name_too_long",
+			target:         strings.Repeat(strings.Repeat("a", 60)+".", 5) + "com",
+			expectedReason: ReasonInvalid,
+		},
+		{
+			name:           "ipv4",
+			target:         "http://127.0.0.1:443",
+			expectedReason: ReasonIPAddress,
+		},
+		{
+			name:           "ipv6",
+			target:         "[::1]",
+			expectedReason: ReasonIPAddress,
+		},
+		{
+			name:           "single_label",
+			target:         "intranet",
+			expectedReason: ReasonReserved,
+		},
+		{
+			name:           "reserved_suffix",
+			target:         "service.namespace.svc.cluster.local",
+			expectedReason: ReasonReserved,
+		},
+	}
+
+	for _, c := range cases {
+		result, err := Target(c.target)
+
+		var reason string
+		var e *Error
+		if errors.As(err, &e) {
+			reason = e.Reason
+		}
+
+		if result != c.expectedResult || reason != c.expectedReason {
+			t.Errorf("Test case : %v failed.\nExpected : (%v, %v)\nGot : (%v, %v)\n", c.name, c.expectedResult, c.expectedReason, result, reason)
+		}
+	}
+}
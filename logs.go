@@ -0,0 +1,131 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/rs/zerolog"
+)
+
+// maximum size of the logs captured per probe or assessment
+const maxCapturedLogsSize = 64 << 10
+
+// logBuffer captures the logs of a single probe or assessment
+type logBuffer struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	truncated bool
+}
+
+// Write implements io.Writer, the logs exceeding the maximum size are dropped
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.buf.Len()+len(p) > maxCapturedLogsSize {
+		b.truncated = true
+		return len(p), nil
+	}
+
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.truncated {
+		return b.buf.String() + "... logs truncated\n"
+	}
+
+	return b.buf.String()
+}
+
+// create a logger writing to the exporter logs output and capturing
+// all the debug logs regardless of the configured log level
+func captureLogger(output io.Writer, buf *logBuffer) log.Logger {
+	return log.New(log.MultiLevelWriter(output, buf)).With().Timestamp().Logger()
+}
+
+// probeLog is the captured logs of a probe
+type probeLog struct {
+	target string
+	time   time.Time
+	logs   string
+}
+
+// probeLogs keeps the logs of the latest probes
+type probeLogs struct {
+	mu sync.Mutex
+
+	// ring buffer of the latest probes logs
+	entries []probeLog
+	next    int
+	size    int
+}
+
+// keep the probe logs, replacing the oldest ones if full
+func (l *probeLogs) add(target, logs string) {
+	if l == nil || len(l.entries) == 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries[l.next] = probeLog{target: target, time: time.Now(), logs: logs}
+	l.next = (l.next + 1) % len(l.entries)
+	if l.size < len(l.entries) {
+		l.size++
+	}
+}
+
+// list the probes logs from the newest to the oldest
+func (l *probeLogs) list() []probeLog {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	logs := make([]probeLog, 0, l.size)
+	for i := 1; i <= l.size; i++ {
+		logs = append(logs, l.entries[(l.next-i+len(l.entries))%len(l.entries)])
+	}
+
+	return logs
+}
+
+// serve the latest probes logs as plain text
+func logsHandler(w http.ResponseWriter, r *http.Request, history *probeLogs) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	target := r.URL.Query().Get("target")
+	for _, entry := range history.list() {
+		if target != "" && entry.target != target {
+			continue
+		}
+
+		fmt.Fprintf(w, "=== %s probe at %s ===\n%s\n", entry.target, entry.time.Format(time.RFC3339), entry.logs)
+	}
+}
+
+// create a store of the latest probes logs, keeping none if size is 0
+func newProbeLogs(size int) *probeLogs {
+	return &probeLogs{entries: make([]probeLog, size)}
+}
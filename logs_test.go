@@ -0,0 +1,81 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCaptureLogger(t *testing.T) {
+	logs := &logBuffer{}
+	logger := captureLogger(io.Discard, logs)
+	logger.Debug().Str("target", "example.com").Msg("debug message")
+
+	if !strings.Contains(logs.String(), "debug message") {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "debug_logs", "debug message", logs.String())
+	}
+
+	// the logs exceeding the maximum size are dropped
+	logs.Write(make([]byte, maxCapturedLogsSize))
+	if !strings.HasSuffix(logs.String(), "logs truncated\n") || len(logs.String()) > maxCapturedLogsSize+100 {
+		t.Errorf("Captured logs were not truncated")
+	}
+}
+
+func TestProbeLogs(t *testing.T) {
+	var cases = []struct {
+		name     string
+		size     int
+		targets  []string
+		expected []string
+	}{
+		{name: "disabled", size: 0, targets: []string{"a", "b"}, expected: []string{}},
+		{name: "not_full", size: 3, targets: []string{"a", "b"}, expected: []string{"b", "a"}},
+		{name: "oldest_replaced", size: 2, targets: []string{"a", "b", "c"}, expected: []string{"c", "b"}},
+	}
+
+	for _, c := range cases {
+		history := newProbeLogs(c.size)
+		for _, target := range c.targets {
+			history.add(target, "logs of "+target)
+		}
+
+		got := []string{}
+		for _, entry := range history.list() {
+			got = append(got, entry.target)
+		}
+
+		if strings.Join(got, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expected, got)
+		}
+	}
+}
+
+func TestLogsHandler(t *testing.T) {
+	history := newProbeLogs(10)
+	history.add("example.com", "logs of example.com\n")
+	history.add("example.org", "logs of example.org\n")
+
+	w := httptest.NewRecorder()
+	logsHandler(w, httptest.NewRequest("GET", "/logs?target=example.org", nil), history)
+
+	body := w.Body.String()
+	if !strings.Contains(body, "logs of example.org") || strings.Contains(body, "logs of example.com") {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "target_filter", "logs of example.org", body)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/config"
+	"github.com/anas-aso/ssllabs_exporter/internal/exporter"
+)
+
+// retentionPolicy decides how long an assessment result is cached
+type retentionPolicy struct {
+	// default retention of successful, failed and ungraded assessments
+	success  time.Duration
+	failed   time.Duration
+	ungraded time.Duration
+
+	// use the SSLLabs cache expiry time of successful assessments when available
+	ssllabsExpiry bool
+
+	// per target overrides
+	config *config.CacheConfig
+}
+
+// create a retention policy applying the same retention to all the results
+func newRetentionPolicy(retention time.Duration) *retentionPolicy {
+	return &retentionPolicy{
+		success:  retention,
+		failed:   retention,
+		ungraded: retention,
+		config:   &config.CacheConfig{},
+	}
+}
+
+// how long the result should be cached, it is not cached if not positive
+func (p *retentionPolicy) ttl(result *exporter.Result) time.Duration {
+	success, failed, ungraded := p.retentions(result.Target)
+
+	if result.Err != nil {
+		return failed
+	}
+
+	if result.Grade() == "" {
+		return ungraded
+	}
+
+	if p.ssllabsExpiry && result.Info != nil && result.Info.CacheExpiryTime > 0 {
+		return time.Until(time.UnixMilli(result.Info.CacheExpiryTime))
+	}
+
+	return success
+}
+
+// the longest retention of the target results, regardless of their outcome
+func (p *retentionPolicy) longest(target string) time.Duration {
+	success, failed, ungraded := p.retentions(target)
+	return max(success, failed, ungraded)
+}
+
+// the retention of the target successful, failed and ungraded results
+func (p *retentionPolicy) retentions(target string) (success, failed, ungraded time.Duration) {
+	success, failed, ungraded = p.success, p.failed, p.ungraded
+
+	if rule := p.config.RetentionRule(target); rule != nil {
+		if rule.Success != nil {
+			success = *rule.Success
+		}
+		if rule.Failed != nil {
+			failed = *rule.Failed
+		}
+		if rule.Ungraded != nil {
+			ungraded = *rule.Ungraded
+		}
+	}
+
+	return success, failed, ungraded
+}
@@ -0,0 +1,118 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	ssllabsApi "github.com/essentialkaos/sslscan/v13"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/config"
+	"github.com/anas-aso/ssllabs_exporter/internal/exporter"
+)
+
+func TestRetentionTTL(t *testing.T) {
+	fiveMinutes := 5 * time.Minute
+	zero := time.Duration(0)
+
+	policy := &retentionPolicy{
+		success:  time.Hour,
+		failed:   time.Minute,
+		ungraded: 10 * time.Minute,
+		config: &config.CacheConfig{
+			Retention: []config.RetentionRule{
+				{
+					Targets: config.TargetMatcher{Suffixes: []string{"staging.example.com"}},
+					Failed:  &zero,
+				},
+				{
+					Targets:  config.TargetMatcher{Suffixes: []string{"example.com"}},
+					Success:  &fiveMinutes,
+					Ungraded: &fiveMinutes,
+				},
+			},
+		},
+	}
+
+	graded := &ssllabsApi.AnalyzeInfo{Endpoints: []*ssllabsApi.EndpointInfo{{Grade: "A"}}}
+	ungraded := &ssllabsApi.AnalyzeInfo{Endpoints: []*ssllabsApi.EndpointInfo{{Grade: ""}}}
+
+	var cases = []struct {
+		name     string
+		result   *exporter.Result
+		expected time.Duration
+	}{
+		{name: "success", result: &exporter.Result{Target: "example.org", Info: graded}, expected: time.Hour},
+		{name: "failed", result: &exporter.Result{Target: "example.org", Err: errors.New("failed")}, expected: time.Minute},
+		{name: "ungraded", result: &exporter.Result{Target: "example.org", Info: ungraded}, expected: 10 * time.Minute},
+		{name: "override_success", result: &exporter.Result{Target: "www.example.com", Info: graded}, expected: 5 * time.Minute},
+		{name: "override_inherited", result: &exporter.Result{Target: "www.example.com", Err: errors.New("failed")}, expected: time.Minute},
+		{name: "override_first_match", result: &exporter.Result{Target: "staging.example.com", Err: errors.New("failed")}, expected: 0},
+	}
+
+	for _, c := range cases {
+		ttl := policy.ttl(c.result)
+		if ttl != c.expected {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expected, ttl)
+		}
+	}
+
+	// the overrides replace the default retention when computing the longest one
+	for target, expected := range map[string]time.Duration{"example.org": time.Hour, "www.example.com": 5 * time.Minute} {
+		if longest := policy.longest(target); longest != expected {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "longest_"+target, expected, longest)
+		}
+	}
+}
+
+func TestRetentionSSLLabsExpiry(t *testing.T) {
+	policy := newRetentionPolicy(time.Hour)
+	policy.ssllabsExpiry = true
+
+	expiry := time.Now().Add(3 * time.Hour)
+	result := &exporter.Result{
+		Target: "example.com",
+		Info: &ssllabsApi.AnalyzeInfo{
+			CacheExpiryTime: expiry.UnixMilli(),
+			Endpoints:       []*ssllabsApi.EndpointInfo{{Grade: "A"}},
+		},
+	}
+
+	ttl := policy.ttl(result)
+	if ttl <= 2*time.Hour || ttl > 3*time.Hour {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "ssllabs_expiry", 3*time.Hour, ttl)
+	}
+
+	// failed results don't have an SSLLabs cache expiry time
+	result.Err = errors.New("failed")
+	if ttl := policy.ttl(result); ttl != time.Hour {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "ssllabs_expiry_failed", time.Hour, ttl)
+	}
+}
+
+func TestCacheSkipsZeroRetention(t *testing.T) {
+	policy := newRetentionPolicy(time.Minute)
+	policy.failed = 0
+
+	cache := newMemoryCache(time.Minute, policy, 0, 0, 0)
+	defer cache.stop()
+
+	cache.add("example.com", &exporter.Result{Target: "example.com", Err: errors.New("failed")})
+	if cache.get("example.com") != nil {
+		t.Errorf("Failed results should not be cached with a zero retention")
+	}
+}
@@ -0,0 +1,341 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/rs/zerolog"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/config"
+	"github.com/anas-aso/ssllabs_exporter/internal/discovery"
+)
+
+// how many scheduled assessments can run at the same time if not configured
+const defaultSchedulerConcurrency = 4
+
+var (
+	// how frequently the scheduler checks which targets are due
+	schedulerTick = 10 * time.Second
+	// minimum time between two scheduled assessments of the same target,
+	// so that the targets which results aren't cached are not assessed continuously
+	schedulerMinDelay = 5 * time.Minute
+)
+
+var (
+	discoveredTargets = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ssllabs_exporter_discovered_targets",
+			Help: "Number of targets scheduled for assessment by discovery source",
+		},
+		[]string{"source"},
+	)
+	scheduledAssessments = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ssllabs_exporter_scheduled_assessments_total",
+			Help: "Number of assessments started by the scheduler by result",
+		},
+		[]string{"result"},
+	)
+)
+
+// scheduledTarget is a discovered target assessed in the background
+type scheduledTarget struct {
+	discovery.Target
+	Source string `json:"source"`
+
+	// when the target cached result is checked again
+	Next time.Time `json:"next_check"`
+	// whether a scheduled assessment of the target is in progress
+	Running bool `json:"running"`
+}
+
+// scheduler keeps the results of the discovered targets in the cache by assessing them
+// before they expire. The targets removed from all the sources are evicted from the cache.
+type scheduler struct {
+	mu sync.Mutex
+
+	// latest targets of each discovery source
+	sources map[string][]discovery.Target
+	// scheduled targets indexed by host, a host discovered by several sources
+	// is scheduled with the settings of the first source in alphabetical order
+	targets map[string]*scheduledTarget
+
+	// number of in-progress scheduled assessments
+	inProgress int
+
+	cfg         *config.SchedulerConfig
+	probeConfig *config.ProbeConfig
+	running     *assessments
+	cache       cache
+	logger      log.Logger
+}
+
+// update the targets of a discovery source
+func (s *scheduler) update(source string, targets []discovery.Target) {
+	// the cache can be remote, so it is updated without holding the lock
+	for _, host := range s.updateTargets(source, targets) {
+		s.cache.remove(host)
+	}
+}
+
+// update the targets of a discovery source and return the hosts
+// removed from all the sources which results must be evicted
+func (s *scheduler) updateTargets(source string, targets []discovery.Target) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sources[source] = targets
+
+	previous := s.targets
+	s.targets = make(map[string]*scheduledTarget)
+
+	for _, name := range slices.Sorted(maps.Keys(s.sources)) {
+		count := 0
+
+		for _, t := range s.sources[name] {
+			if _, found := s.targets[t.Host]; found {
+				continue
+			}
+
+			if _, found := s.cfg.Modules[t.Module]; t.Module != "" && !found {
+				s.logger.Error().Str("source", name).Str("target", t.Host).Str("module", t.Module).Msg("unknown scheduler module, ignoring the target")
+				continue
+			}
+
+			if err := allowed(t.Host, s.probeConfig); err != nil {
+				s.logger.Warn().Err(err).Str("source", name).Msg("target not allowed, ignoring it")
+				continue
+			}
+
+			// keep the schedule of the targets already known
+			scheduled, found := previous[t.Host]
+			if !found {
+				scheduled = &scheduledTarget{}
+			}
+			scheduled.Target = t
+			scheduled.Source = name
+
+			s.targets[t.Host] = scheduled
+			count++
+		}
+
+		discoveredTargets.WithLabelValues(name).Set(float64(count))
+	}
+
+	var removed []string
+	for host, t := range previous {
+		if _, found := s.targets[host]; found {
+			continue
+		}
+
+		s.logger.Info().Str("target", host).Str("source", t.Source).Msg("target removed from discovery")
+
+		// the results of the in-progress assessments are evicted once they finish
+		if !t.Running {
+			removed = append(removed, host)
+		}
+	}
+
+	return removed
+}
+
+// assess the due targets until the context is done
+func (s *scheduler) run(ctx context.Context) {
+	ticker := time.NewTicker(schedulerTick)
+	defer ticker.Stop()
+
+	for {
+		s.schedule(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// start the assessments of the targets which results are missing from the cache,
+// expired or older than their interval, up to the scheduler concurrency
+func (s *scheduler) schedule(ctx context.Context) {
+	now := time.Now()
+
+	for _, host := range s.due(now) {
+		// the cache can be remote, so it is checked without holding the lock
+		cached, found := s.cache.lookup(host)
+
+		s.mu.Lock()
+		full := s.inProgress >= s.concurrency()
+		t, scheduled := s.targets[host]
+
+		switch {
+		case full:
+		case !scheduled || t.Running:
+			// the target was removed or started while checking the cache
+		case found && now.Before(s.next(t, cached)):
+			t.Next = s.next(t, cached)
+		default:
+			s.start(ctx, t)
+		}
+		s.mu.Unlock()
+
+		if full {
+			return
+		}
+	}
+}
+
+// hosts of the targets which cached results must be checked, sorted by host
+func (s *scheduler) due(now time.Time) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.inProgress >= s.concurrency() {
+		return nil
+	}
+
+	var hosts []string
+	for host, t := range s.targets {
+		if !t.Running && !now.Before(t.Next) {
+			hosts = append(hosts, host)
+		}
+	}
+	slices.Sort(hosts)
+
+	return hosts
+}
+
+// when the cached result of the target must be refreshed
+func (s *scheduler) next(t *scheduledTarget, cached cachedResult) time.Time {
+	next := cached.expiryTime
+	if interval := s.interval(t.Module); interval > 0 && cached.result.Start.Add(interval).Before(next) {
+		next = cached.result.Start.Add(interval)
+	}
+
+	return next
+}
+
+// maximum number of scheduled assessments in progress
+func (s *scheduler) concurrency() int {
+	if s.cfg.Concurrency != nil {
+		return *s.cfg.Concurrency
+	}
+
+	return defaultSchedulerConcurrency
+}
+
+// start a background assessment of the target
+func (s *scheduler) start(ctx context.Context, t *scheduledTarget) {
+	s.logger.Debug().Str("target", t.Host).Str("source", t.Source).Msg("starting scheduled assessment")
+
+	t.Running = true
+	t.Next = time.Now().Add(schedulerMinDelay)
+	s.inProgress++
+
+	var as *assessment
+	if s.cfg.Modules[t.Module].StartNew {
+		as = s.running.refresh(ctx, t.Host)
+	} else {
+		as = s.running.start(ctx, t.Host)
+	}
+
+	go func() {
+		<-as.done
+
+		outcome := "success"
+		if as.result.Err != nil {
+			outcome = "failure"
+		}
+		scheduledAssessments.WithLabelValues(outcome).Inc()
+
+		s.mu.Lock()
+		t.Running = false
+		s.inProgress--
+		_, scheduled := s.targets[t.Host]
+		s.mu.Unlock()
+
+		// the target was removed from discovery during the assessment
+		if !scheduled {
+			s.cache.remove(t.Host)
+		}
+	}()
+}
+
+// maximum age of the results of the targets referring to the module, 0 if not set
+func (s *scheduler) interval(module string) time.Duration {
+	if m, found := s.cfg.Modules[module]; found && m.Interval != nil {
+		return *m.Interval
+	}
+
+	if s.cfg.Interval != nil {
+		return *s.cfg.Interval
+	}
+
+	return 0
+}
+
+// labels of the scheduled target, nil if the target wasn't discovered
+func (s *scheduler) labels(target string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, found := s.targets[target]; found {
+		return t.Labels
+	}
+
+	return nil
+}
+
+// list the scheduled targets sorted by host, none if no discovery source is configured
+func (s *scheduler) list() []scheduledTarget {
+	if s == nil {
+		return []scheduledTarget{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	targets := make([]scheduledTarget, 0, len(s.targets))
+	for _, t := range s.targets {
+		targets = append(targets, *t)
+	}
+
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].Host < targets[j].Host
+	})
+
+	return targets
+}
+
+// create a scheduler of the assessments of the discovered targets
+func newScheduler(logger log.Logger, cfg *config.SchedulerConfig, probeConfig *config.ProbeConfig, running *assessments, resultsCache cache) *scheduler {
+	return &scheduler{
+		sources:     make(map[string][]discovery.Target),
+		targets:     make(map[string]*scheduledTarget),
+		cfg:         cfg,
+		probeConfig: probeConfig,
+		running:     running,
+		cache:       resultsCache,
+		logger:      logger,
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/rs/zerolog"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/config"
+	"github.com/anas-aso/ssllabs_exporter/internal/discovery"
+	"github.com/anas-aso/ssllabs_exporter/internal/exporter"
+)
+
+// create a scheduler of fake assessments, returning the number of assessments per target
+func newTestScheduler(cfg *config.SchedulerConfig, probeConfig *config.ProbeConfig) (*scheduler, *memoryCache, func(string) int) {
+	resultsCache := newMemoryCache(time.Minute, newRetentionPolicy(time.Hour), 0, 0, 0)
+	running := newAssessments(context.Background(), log.Nop(), time.Minute, resultsCache, false)
+
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	running.handle = func(ctx context.Context, logger log.Logger, target string) *exporter.Result {
+		mu.Lock()
+		calls[target]++
+		mu.Unlock()
+
+		return gradedResult(target, "A", time.Now())
+	}
+
+	count := func(target string) int {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return calls[target]
+	}
+
+	return newScheduler(log.Nop(), cfg, probeConfig, running, resultsCache), resultsCache, count
+}
+
+// wait until the scheduled assessments finish
+func waitScheduled(t *testing.T, s *scheduler) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		inProgress := s.inProgress
+		s.mu.Unlock()
+
+		if inProgress == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatal("scheduled assessments did not finish")
+}
+
+func TestSchedulerAssessments(t *testing.T) {
+	hour := time.Hour
+	cfg := &config.SchedulerConfig{
+		Modules: map[string]config.ModuleConfig{"hourly": {Interval: &hour}},
+	}
+	probeConfig := &config.ProbeConfig{Deny: config.TargetMatcher{Suffixes: []string{"denied.com"}}}
+	s, resultsCache, count := newTestScheduler(cfg, probeConfig)
+
+	s.update("file/0", []discovery.Target{
+		{Host: "example.com", Labels: map[string]string{"team": "web"}},
+		{Host: "example.org", Module: "hourly"},
+		{Host: "unknown-module.com", Module: "daily"},
+		{Host: "denied.com"},
+	})
+	s.update("file/1", []discovery.Target{
+		{Host: "example.com", Labels: map[string]string{"team": "other"}},
+		{Host: "example.net"},
+	})
+
+	s.schedule(context.Background())
+	waitScheduled(t, s)
+
+	var cases = []struct {
+		name          string
+		target        string
+		expectedCalls int
+	}{
+		{name: "discovered_target", target: "example.com", expectedCalls: 1},
+		{name: "module_target", target: "example.org", expectedCalls: 1},
+		{name: "second_source_target", target: "example.net", expectedCalls: 1},
+		{name: "unknown_module", target: "unknown-module.com", expectedCalls: 0},
+		{name: "denied_target", target: "denied.com", expectedCalls: 0},
+	}
+
+	for _, c := range cases {
+		if got := count(c.target); got != c.expectedCalls {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedCalls, got)
+		}
+	}
+
+	targets := s.list()
+	if len(targets) != 3 || targets[0].Host != "example.com" || targets[0].Source != "file/0" || targets[0].Labels["team"] != "web" {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "targets_list", "example.com discovered by file/0", targets)
+	}
+
+	// the cached results are fresh until they expire or get older than the module interval
+	s.targets["example.com"].Next = time.Time{}
+	s.schedule(context.Background())
+	waitScheduled(t, s)
+	if got := count("example.com"); got != 1 {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "fresh_result", 1, got)
+	}
+	if next := s.targets["example.org"].Next; next.After(time.Now().Add(hour)) {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "module_interval", "at most an hour", next)
+	}
+
+	// the targets removed from all the sources are evicted from the cache
+	s.update("file/0", []discovery.Target{{Host: "example.org", Module: "hourly"}})
+	s.update("file/1", []discovery.Target{{Host: "example.net"}})
+	var evictions = []struct {
+		name          string
+		target        string
+		expectedFound bool
+	}{
+		{name: "removed_target", target: "example.com", expectedFound: false},
+		{name: "kept_target", target: "example.org", expectedFound: true},
+		{name: "other_source_target", target: "example.net", expectedFound: true},
+	}
+
+	for _, c := range evictions {
+		if _, found := resultsCache.lookup(c.target); found != c.expectedFound {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedFound, found)
+		}
+	}
+}
+
+func TestSchedulerInterval(t *testing.T) {
+	minute := time.Minute
+	cfg := &config.SchedulerConfig{
+		Modules: map[string]config.ModuleConfig{"frequent": {Interval: &minute}},
+	}
+
+	var cases = []struct {
+		name          string
+		age           time.Duration
+		expectedCalls int
+	}{
+		{name: "within_interval", age: 30 * time.Second, expectedCalls: 0},
+		{name: "past_interval_not_expired", age: 10 * time.Minute, expectedCalls: 1},
+	}
+
+	for _, c := range cases {
+		s, resultsCache, count := newTestScheduler(cfg, &config.ProbeConfig{})
+
+		// the cached result expires after an hour, long after the module interval
+		resultsCache.add("example.com", gradedResult("example.com", "B", time.Now().Add(-c.age)))
+		s.update("file/0", []discovery.Target{{Host: "example.com", Module: "frequent"}})
+
+		s.schedule(context.Background())
+		waitScheduled(t, s)
+
+		if got := count("example.com"); got != c.expectedCalls {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedCalls, got)
+		}
+
+		expectedGrade := "B"
+		if c.expectedCalls > 0 {
+			expectedGrade = "A"
+		}
+		if result := resultsCache.get("example.com"); result == nil || result.Grade() != expectedGrade {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name+"_cached", expectedGrade, result)
+		}
+
+		resultsCache.stop()
+	}
+}
+
+func TestSchedulerConcurrency(t *testing.T) {
+	concurrency := 1
+	s, _, _ := newTestScheduler(&config.SchedulerConfig{Concurrency: &concurrency}, &config.ProbeConfig{})
+
+	// fake an assessment that only finishes when asked to
+	release := make(chan struct{})
+	s.running.handle = func(ctx context.Context, logger log.Logger, target string) *exporter.Result {
+		<-release
+		return gradedResult(target, "A", time.Now())
+	}
+
+	s.update("file/0", []discovery.Target{{Host: "example.com"}, {Host: "example.org"}})
+	s.schedule(context.Background())
+
+	if running := len(s.running.list()); running != 1 {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "concurrency", 1, running)
+	}
+
+	// the target removed during its assessment is evicted once it finishes
+	s.update("file/0", []discovery.Target{{Host: "example.org"}})
+	close(release)
+	waitScheduled(t, s)
+
+	if _, found := s.cache.lookup("example.com"); found {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "removed_during_assessment", false, found)
+	}
+}
+
+// cache which lookups block until released, like an unresponsive Redis server
+type slowCache struct {
+	cache
+	release chan struct{}
+}
+
+func (c *slowCache) lookup(id string) (cachedResult, bool) {
+	<-c.release
+	return c.cache.lookup(id)
+}
+
+func TestSchedulerSlowCache(t *testing.T) {
+	s, resultsCache, count := newTestScheduler(&config.SchedulerConfig{}, &config.ProbeConfig{})
+	slow := &slowCache{cache: resultsCache, release: make(chan struct{})}
+	s.cache = slow
+
+	s.update("file/0", []discovery.Target{{Host: "example.com"}, {Host: "example.org"}})
+
+	scheduled := make(chan struct{})
+	go func() {
+		s.schedule(context.Background())
+		close(scheduled)
+	}()
+
+	// the discovery updates are not blocked by the cache lookups
+	updated := make(chan struct{})
+	go func() {
+		s.update("file/0", []discovery.Target{{Host: "example.org"}})
+		s.list()
+		close(updated)
+	}()
+
+	select {
+	case <-updated:
+	case <-time.After(time.Second):
+		t.Fatalf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "update_during_lookup", "update done", "blocked")
+	}
+
+	close(slow.release)
+	<-scheduled
+	waitScheduled(t, s)
+
+	// the target removed while its cache entry was checked isn't assessed
+	if got := count("example.com") + count("example.org"); got != 1 || count("example.org") != 1 {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "removed_during_lookup", 1, got)
+	}
+}
+
+func TestTargetsAPI(t *testing.T) {
+	var cases = []struct {
+		name            string
+		scheduler       *scheduler
+		expectedTargets int
+	}{
+		{name: "discovery_disabled", scheduler: nil, expectedTargets: 0},
+		{name: "discovered_targets", scheduler: func() *scheduler {
+			s, _, _ := newTestScheduler(&config.SchedulerConfig{}, &config.ProbeConfig{})
+			s.update("file/0", []discovery.Target{{Host: "example.com"}})
+			return s
+		}(), expectedTargets: 1},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		targetsHandler(w, httptest.NewRequest("GET", "/api/v1/targets", nil), c.scheduler)
+
+		var targets []scheduledTarget
+		if err := json.Unmarshal(w.Body.Bytes(), &targets); err != nil || len(targets) != c.expectedTargets {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedTargets, w.Body.String())
+		}
+	}
+}
@@ -16,80 +16,274 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"maps"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/exporter-toolkit/web"
 	log "github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/anas-aso/ssllabs_exporter/internal/build"
+	"github.com/anas-aso/ssllabs_exporter/internal/config"
+	"github.com/anas-aso/ssllabs_exporter/internal/discovery"
 	"github.com/anas-aso/ssllabs_exporter/internal/exporter"
 	"github.com/anas-aso/ssllabs_exporter/internal/ssllabs"
+	"github.com/anas-aso/ssllabs_exporter/internal/validation"
 )
 
 const (
 	pruneDelay = 1 * time.Minute
+
+	// how long to wait for the remaining spans to be exported on shutdown
+	tracingFlushTimeout = 5 * time.Second
 )
 
 var (
 	listenAddress     = kingpin.Flag("listen-address", "The address to listen on for HTTP requests.").Default(":19115").String()
+	webConfigFile     = kingpin.Flag("web-config-file", "Path to the optional web configuration file enabling TLS and basic authentication. See https://github.com/prometheus/exporter-toolkit/blob/master/docs/web-configuration.md").Default("").String()
+	configFile        = kingpin.Flag("config-file", "Path to the optional configuration file.").Default("").String()
 	probeTimeout      = kingpin.Flag("timeout", "Time duration before canceling an ongoing probe such as 30m or 1h5m. This value must be at least 1m. Valid duration units are ns, us (or µs), ms, s, m, h.").Default("10m").String()
 	logLevel          = kingpin.Flag("log-level", "Printed logs level.").Default("debug").Enum("error", "warn", "info", "debug")
 	cacheRetention    = kingpin.Flag("cache-retention", "Time duration to keep entries in cache such as 30m or 1h5m. Valid duration units are ns, us (or µs), ms, s, m, h.").Default("1h").String()
 	cacheIgnoreFailed = kingpin.Flag("cache-ignore-failed", "Do not cache failed results due to intermittent SSLLabs issues.").Default("False").Bool()
+	cacheFailed       = kingpin.Flag("cache-failed-retention", "Time duration to keep failed results in cache, 0 disables caching them. Defaults to the cache retention. Valid duration units are ns, us (or µs), ms, s, m, h.").Default("").String()
+	cacheUngraded     = kingpin.Flag("cache-ungraded-retention", "Time duration to keep results without a graded endpoint in cache. Defaults to the cache retention. Valid duration units are ns, us (or µs), ms, s, m, h.").Default("").String()
+	cacheBackend      = kingpin.Flag("cache-backend", "Where the results are cached, redis shares them between multiple exporter instances.").Default("memory").Enum("memory", "redis")
+	cacheRedisURL     = kingpin.Flag("cache-redis-url", "URL of the Redis server used by the redis cache backend such as redis://<user>:<password>@<host>:<port>/<db>.").Default("redis://localhost:6379/0").String()
+	cacheRedisPrefix  = kingpin.Flag("cache-redis-prefix", "Prefix of the Redis keys used by the redis cache backend.").Default("ssllabs_exporter:").String()
+	cacheSSLLabs      = kingpin.Flag("cache-ssllabs-expiry", "Keep successful results in cache until they expire from the SSLLabs cache instead of using the cache retention.").Default("False").Bool()
+	cacheMaxEntries   = kingpin.Flag("cache-max-entries", "Maximum number of cached results, the least recently used ones are evicted first. 0 means unlimited.").Default("0").Int()
+	cacheMaxBytes     = kingpin.Flag("cache-max-bytes", "Maximum estimated memory used by the cached results in bytes, the least recently used ones are evicted first. 0 means unlimited.").Default("0").Int64()
+	pollInterval      = kingpin.Flag("poll-interval", "Minimum time duration between two SSLLabs assessment updates requests such as 10s or 1m. Valid duration units are ns, us (or µs), ms, s, m, h.").Default("10s").String()
+	shutdownGrace     = kingpin.Flag("shutdown-grace-period", "Time duration to wait for in-progress assessments to finish on shutdown such as 30s or 1m. Valid duration units are ns, us (or µs), ms, s, m, h.").Default("30s").String()
+	historySize       = kingpin.Flag("history-size", "Number of assessments kept in the history of each target, used to detect grade changes. 0 disables it.").Default("10").Int()
+	logsHistory       = kingpin.Flag("logs-history", "Number of the latest probes logs available on the /logs admin endpoint, 0 disables it.").Default("100").Int()
+	pollJitter        = kingpin.Flag("poll-jitter", "Maximum random time duration added to the poll interval to spread SSLLabs API requests. Valid duration units are ns, us (or µs), ms, s, m, h.").Default("10s").String()
+
+	_                    = kingpin.Command("serve", "Run the exporter HTTP server.").Default()
+	checkCommand         = kingpin.Command("check", "Assess the targets once, print a report and exit with a non-zero code if any of them fails the checks.")
+	checkTargets         = checkCommand.Flag("target", "Host name to assess, can be repeated.").Required().Strings()
+	checkMinGrade        = checkCommand.Flag("min-grade", "Fail the targets with a grade below this one such as A or B.").Default("").String()
+	checkVulnerabilities = checkCommand.Flag("fail-on-vulnerability", "Fail the targets with this vulnerability such as heartbleed or robot, can be repeated. \"any\" fails the targets with any vulnerability.").Strings()
+	checkOutput          = checkCommand.Flag("output", "Format of the report.").Default("table").Enum("table", "json")
+)
+
+// prefix of the probe query parameters adding labels to the metrics
+const labelParamPrefix = "label_"
+
+// cache label values of the probes metrics
+const (
+	cacheHit  = "hit"
+	cacheMiss = "miss"
+)
+
+var (
+	probesServed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ssllabs_exporter_probes_total",
+			Help: "Number of served probes by result and whether they were served from the cache",
+		},
+		[]string{"result", "cache"},
+	)
+	probeLatency = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "ssllabs_exporter_probe_latency_seconds",
+			Help:    "Time taken to serve the probes by whether they were served from the cache",
+			Buckets: []float64{0.01, 0.1, 1, 10, 30, 60, 120, 300, 600, 1200},
+		},
+		[]string{"cache"},
+	)
 )
 
-func probeHandler(w http.ResponseWriter, r *http.Request, logger log.Logger, timeoutSeconds time.Duration, resultsCache *cache) {
-	target := r.URL.Query().Get("target")
-	// TODO: add more validation for the target (e.g valid hostname, DNS, etc)
-	if target == "" {
-		logger.Error().Msg("Target parameter is missing")
-		http.Error(w, "Target parameter is missing", http.StatusBadRequest)
+func probeHandler(w http.ResponseWriter, r *http.Request, timeoutSeconds time.Duration, resultsCache cache, running *assessments, probeConfig *config.ProbeConfig, history *probeLogs) {
+	// the probe logs are returned with the results if debug is enabled
+	logs := &logBuffer{}
+	logger := captureLogger(running.logOutput, logs)
+	debug := r.URL.Query().Get("debug") == "true"
+
+	// continue the trace of the caller if any
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer().Start(ctx, "probe", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	if !authorized(r, &probeConfig.AuthConfig) {
+		logger.Error().Str("remote_address", r.RemoteAddr).Msg("Unauthorized probe request")
+		probesRejected.WithLabelValues(reasonUnauthorized).Inc()
+		w.Header().Set("WWW-Authenticate", authenticateHeader(&probeConfig.AuthConfig))
+		jsonError(w, http.StatusUnauthorized, &validation.Error{Reason: reasonUnauthorized, Message: "missing or invalid credentials"})
+		return
+	}
+
+	// the normalized target is used as the cache key, so that different forms
+	// of the same host (e.g URL, uppercase, etc) share the same assessment
+	target, err := validation.Target(r.URL.Query().Get("target"))
+	if err != nil {
+		logger.Error().Err(err).Msg("Invalid target")
+		probesRejected.WithLabelValues(rejectionReason(err)).Inc()
+		jsonError(w, http.StatusBadRequest, err)
 		return
 	}
 
+	if err := allowed(target, probeConfig); err != nil {
+		logger.Error().Err(err).Msg("Target not allowed")
+		probesRejected.WithLabelValues(validation.ReasonDenied).Inc()
+		jsonError(w, http.StatusForbidden, err)
+		return
+	}
+
+	labels, err := probeLabels(r.URL.Query(), running.targetLabels(target))
+	if err != nil {
+		logger.Error().Err(err).Msg("Invalid target labels")
+		probesRejected.WithLabelValues(rejectionReason(err)).Inc()
+		jsonError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	start := time.Now()
+	cacheUsage := cacheHit
+	var assessmentLogs *logBuffer
+
+	span.SetAttributes(attribute.String("target", target))
+
 	// check if the results are available in the cache
-	registry := resultsCache.get(target)
+	_, cacheSpan := tracer().Start(ctx, "cache.get", trace.WithAttributes(attribute.String("target", target)))
+	result := resultsCache.get(target)
+	cacheSpan.SetAttributes(attribute.Bool("hit", result != nil))
+	cacheSpan.End()
 
-	if registry != nil {
+	if result != nil {
 		logger.Debug().Str("target", target).Msg("serving results from cache")
 	} else {
+		cacheUsage = cacheMiss
+
 		// if the results do not exist in the cache, trigger a new assessment
+		// or wait for the one started by a previous probe. The results are
+		// added to the cache once the assessment finishes, even if this probe
+		// times out before.
 
 		timeoutSeconds = getTimeout(r, timeoutSeconds)
 
-		ctx, cancel := context.WithTimeout(r.Context(), timeoutSeconds)
+		ctx, cancel := context.WithTimeout(ctx, timeoutSeconds)
 		defer cancel()
 
 		r = r.WithContext(ctx)
 
-		registry = exporter.Handle(ctx, logger, target)
+		as := running.start(ctx, target)
+		result = as.wait(ctx)
+		assessmentLogs = as.logs
+	}
 
-		// do not cache failed assessments if configured
-		if *cacheIgnoreFailed && exporter.Failed(registry) {
-			return
-		}
+	// the cached results are shared by the probes with different labels
+	if !maps.Equal(result.Labels, labels) {
+		result = result.WithLabels(labels)
+	}
 
-		// add the assessment results to the cache
-		resultsCache.add(target, registry)
+	outcome := "success"
+	if result.Err != nil {
+		outcome = "failure"
 	}
+	probesServed.WithLabelValues(outcome, cacheUsage).Inc()
+	span.SetAttributes(attribute.String("cache", cacheUsage))
+	setResultAttributes(span, result)
+	probeLatency.WithLabelValues(cacheUsage).Observe(time.Since(start).Seconds())
+
+	// include the logs of the assessment, which may have been started by a previous probe
+	probeLogs := logs.String()
+	if assessmentLogs != nil {
+		probeLogs += assessmentLogs.String()
+	}
+	history.add(target, probeLogs)
 
-	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	if debug {
+		debugResponse(w, probeLogs, result.Registry)
+		return
+	}
+
+	h := promhttp.HandlerFor(result.Registry, promhttp.HandlerOpts{})
 	h.ServeHTTP(w, r)
 }
 
+// merge the discovered labels of the target with the label_<name>=<value> query parameters,
+// which can't override the discovered ones with another value
+func probeLabels(query url.Values, discovered map[string]string) (map[string]string, error) {
+	labels := maps.Clone(discovered)
+	params := make(map[string]string)
+
+	for key, values := range query {
+		name, found := strings.CutPrefix(key, labelParamPrefix)
+		if !found {
+			continue
+		}
+
+		if current, found := discovered[name]; found && current != values[0] {
+			return nil, &validation.Error{Label: name, Reason: validation.ReasonLabelConflict, Message: "label already set to another value by the target discovery"}
+		}
+		params[name] = values[0]
+	}
+
+	if err := validation.Labels(params); err != nil {
+		return nil, err
+	}
+
+	if len(params) > 0 && labels == nil {
+		labels = make(map[string]string, len(params))
+	}
+	maps.Copy(labels, params)
+
+	return labels, nil
+}
+
+// write the probe logs and the metrics it would have returned as plain text
+func debugResponse(w http.ResponseWriter, logs string, registry prometheus.Gatherer) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	fmt.Fprintf(w, "Logs for the probe:\n%s\n\nMetrics that would have been returned:\n", logs)
+
+	mfs, err := registry.Gather()
+	if err != nil {
+		fmt.Fprintf(w, "failed to gather the metrics: %v\n", err)
+		return
+	}
+
+	for _, mf := range mfs {
+		if _, err := expfmt.MetricFamilyToText(w, mf); err != nil {
+			return
+		}
+	}
+}
+
 func main() {
 	kingpin.Version(build.Version)
-	kingpin.Parse()
+	command := kingpin.Parse()
+
+	// the check command report is written to the standard output
+	logWriter := os.Stdout
+	if command == checkCommand.FullCommand() {
+		logWriter = os.Stderr
+	}
 
-	logger, err := createLogger(*logLevel)
+	logger, logOutput, err := createLogger(*logLevel, logWriter)
 	if err != nil {
 		fmt.Printf("failed to create logger with error: %v", err)
 		os.Exit(1)
@@ -101,12 +295,148 @@ func main() {
 		os.Exit(1)
 	}
 
+	ssllabs.PollInterval, err = time.ParseDuration(*pollInterval)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to parse the poll interval value")
+		os.Exit(1)
+	}
+
+	ssllabs.PollJitter, err = time.ParseDuration(*pollJitter)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to parse the poll jitter value")
+		os.Exit(1)
+	}
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to load the configuration file")
+		os.Exit(1)
+	}
+
+	exporter.SetPolicies(cfg.Policies)
+
+	if command == checkCommand.FullCommand() {
+		opts := checkOptions{
+			minGrade:        *checkMinGrade,
+			vulnerabilities: *checkVulnerabilities,
+			timeout:         timeoutSeconds,
+			output:          *checkOutput,
+			labels:          cfg.Discovery.StaticLabels,
+		}
+		if err := opts.validate(); err != nil {
+			logger.Error().Err(err).Msg("failed to validate the check options")
+			os.Exit(2)
+		}
+
+		os.Exit(runCheck(context.Background(), logger, os.Stdout, *checkTargets, opts, exporter.Handle))
+	}
+
+	if err := web.Validate(*webConfigFile); err != nil {
+		logger.Error().Err(err).Msg("failed to validate the web configuration file")
+		os.Exit(1)
+	}
+
+	shutdownTracing, err := setupTracing(&cfg.Tracing)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to setup tracing")
+		os.Exit(1)
+	}
+
 	cacheRetentionDuration, err := time.ParseDuration(*cacheRetention)
 	if err != nil {
 		logger.Error().Err(err).Msg("failed to parse the cache retention value")
 		os.Exit(1)
 	}
-	resultsCache := newCache(pruneDelay, cacheRetentionDuration)
+	retention := newRetentionPolicy(cacheRetentionDuration)
+	retention.ssllabsExpiry = *cacheSSLLabs
+	retention.config = &cfg.Cache
+	if *cacheFailed != "" {
+		if retention.failed, err = time.ParseDuration(*cacheFailed); err != nil {
+			logger.Error().Err(err).Msg("failed to parse the cache failed retention value")
+			os.Exit(1)
+		}
+	}
+	if *cacheUngraded != "" {
+		if retention.ungraded, err = time.ParseDuration(*cacheUngraded); err != nil {
+			logger.Error().Err(err).Msg("failed to parse the cache ungraded retention value")
+			os.Exit(1)
+		}
+	}
+	if *cacheMaxEntries < 0 || *cacheMaxBytes < 0 {
+		logger.Error().Msg("cache size limits must not be negative")
+		os.Exit(1)
+	}
+	if *historySize < 0 {
+		logger.Error().Msg("history size must not be negative")
+		os.Exit(1)
+	}
+	// the webhooks are notified about the changes since the previous assessment in the history
+	if *historySize == 0 && len(cfg.Webhooks) > 0 {
+		logger.Error().Msg("webhooks require the assessments history, --history-size must be positive")
+		os.Exit(1)
+	}
+
+	var resultsCache cache
+	switch *cacheBackend {
+	case "redis":
+		resultsCache, err = newRedisCache(logger, *cacheRedisURL, *cacheRedisPrefix, retention, *historySize)
+		if err != nil {
+			logger.Error().Err(err).Msg("failed to connect to the Redis cache")
+			os.Exit(1)
+		}
+	default:
+		resultsCache = newMemoryCache(pruneDelay, retention, *cacheMaxEntries, *cacheMaxBytes, *historySize)
+	}
+	prometheus.MustRegister(resultsCache)
+
+	shutdownGracePeriod, err := time.ParseDuration(*shutdownGrace)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to parse the shutdown grace period value")
+		os.Exit(1)
+	}
+
+	running := newAssessments(context.Background(), logger, timeoutSeconds, resultsCache, *cacheIgnoreFailed)
+	running.logOutput = logOutput
+	running.webhooks = newWebhooks(logger, cfg.Webhooks)
+	prometheus.MustRegister(running)
+
+	// the discovered targets are assessed in the background until the shutdown
+	discoveryCtx, stopDiscovery := context.WithCancel(context.Background())
+	defer stopDiscovery()
+
+	var sched *scheduler
+	if cfg.Discovery.Enabled() {
+		sched = newScheduler(logger, &cfg.Scheduler, &cfg.Probe, running, resultsCache)
+		running.labels = sched.labels
+
+		// the static targets are validated when the configuration is loaded
+		if len(cfg.Discovery.Static) > 0 {
+			staticTargets, _ := discovery.Targets(cfg.Discovery.Static)
+			sched.update("static", staticTargets)
+		}
+
+		for i := range cfg.Discovery.Files {
+			d := discovery.NewFile(fmt.Sprintf("file/%d", i), &cfg.Discovery.Files[i], logger)
+			go d.Run(discoveryCtx, sched.update)
+		}
+
+		for i := range cfg.Discovery.Kubernetes {
+			d, err := discovery.NewKubernetes(fmt.Sprintf("kubernetes/%d", i), &cfg.Discovery.Kubernetes[i], logger)
+			if err != nil {
+				logger.Error().Err(err).Msg("failed to create the kubernetes discovery")
+				os.Exit(1)
+			}
+			go d.Run(discoveryCtx, sched.update)
+		}
+
+		go sched.run(discoveryCtx)
+	}
+
+	if *logsHistory < 0 {
+		logger.Error().Msg("logs history size must not be negative")
+		os.Exit(1)
+	}
+	history := newProbeLogs(*logsHistory)
 
 	logger.Info().Str("version", build.Version).Msg("Starting ssllabs_exporter")
 
@@ -145,27 +475,120 @@ func main() {
 	http.Handle("/metrics", promhttp.Handler())
 
 	http.HandleFunc("/probe", func(w http.ResponseWriter, r *http.Request) {
-		probeHandler(w, r, logger, timeoutSeconds, resultsCache)
+		probeHandler(w, r, timeoutSeconds, resultsCache, running, &cfg.Probe, history)
 	})
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html")
-		w.Write([]byte(`<html>
-    <head><title>SSLLabs Exporter</title></head>
-    <body>
-    <h1>SSLLabs Exporter</h1>
-    <p><a href="probe?target=prometheus.io">Check SSLLabs grade for prometheus.io</a></p>
-    <p><a href="metrics">Exporter Metrics</a></p>
-    </body>
-    </html>`))
-	})
+	http.HandleFunc("GET /logs", adminOnly(logger, &cfg.Admin, func(w http.ResponseWriter, r *http.Request) {
+		logsHandler(w, r, history)
+	}))
+
+	http.HandleFunc("GET /api/v1/results", authenticated(logger, &cfg.Probe.AuthConfig, func(w http.ResponseWriter, r *http.Request) {
+		resultsHandler(w, r, resultsCache)
+	}))
+
+	http.HandleFunc("GET /api/v1/results/{target}", authenticated(logger, &cfg.Probe.AuthConfig, func(w http.ResponseWriter, r *http.Request) {
+		resultHandler(w, r, logger, resultsCache)
+	}))
+
+	http.HandleFunc("GET /api/v1/results/{target}/history", authenticated(logger, &cfg.Probe.AuthConfig, func(w http.ResponseWriter, r *http.Request) {
+		historyHandler(w, r, logger, resultsCache)
+	}))
+
+	http.HandleFunc("GET /api/v1/results/{target}/diff", authenticated(logger, &cfg.Probe.AuthConfig, func(w http.ResponseWriter, r *http.Request) {
+		diffHandler(w, r, logger, resultsCache)
+	}))
+
+	http.HandleFunc("GET /api/v1/targets", authenticated(logger, &cfg.Probe.AuthConfig, func(w http.ResponseWriter, r *http.Request) {
+		targetsHandler(w, r, sched)
+	}))
+
+	http.HandleFunc("GET /api/v1/cache", adminOnly(logger, &cfg.Admin, func(w http.ResponseWriter, r *http.Request) {
+		cacheListHandler(w, r, resultsCache)
+	}))
+
+	http.HandleFunc("DELETE /api/v1/cache/{target}", adminOnly(logger, &cfg.Admin, func(w http.ResponseWriter, r *http.Request) {
+		cacheEvictHandler(w, r, logger, resultsCache)
+	}))
+
+	http.HandleFunc("POST /api/v1/cache/{target}/refresh", adminOnly(logger, &cfg.Admin, func(w http.ResponseWriter, r *http.Request) {
+		cacheRefreshHandler(w, r, logger, running, &cfg.Probe)
+	}))
+
+	http.HandleFunc("GET /{$}", authenticated(logger, &cfg.Probe.AuthConfig, func(w http.ResponseWriter, r *http.Request) {
+		indexHandler(w, r, logger, resultsCache, running, cfg.Admin.Enabled())
+	}))
+
+	http.HandleFunc("POST /ui/refresh", adminOnly(logger, &cfg.Admin, func(w http.ResponseWriter, r *http.Request) {
+		refreshFormHandler(w, r, logger, running, &cfg.Probe)
+	}))
+
+	http.HandleFunc("POST /ui/evict", adminOnly(logger, &cfg.Admin, func(w http.ResponseWriter, r *http.Request) {
+		evictFormHandler(w, r, logger, resultsCache)
+	}))
+
+	server := &http.Server{}
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+
+		logger.Info().Dur("grace_period", shutdownGracePeriod).Msg("Shutting down")
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownGracePeriod)
+		defer cancel()
+
+		// stop accepting new probes and wait for the ongoing ones
+		if err := server.Shutdown(ctx); err != nil {
+			logger.Error().Err(err).Msg("Error shutting down HTTP server")
+		}
 
-	logger.Info().Str("address", *listenAddress).Msg("Listening on address")
+		// stop scheduling new assessments
+		stopDiscovery()
 
-	if err := http.ListenAndServe(*listenAddress, nil); err != nil {
+		// wait for the assessments the probes stopped waiting for
+		running.shutdown(ctx)
+		running.webhooks.shutdown(ctx)
+		resultsCache.stop()
+
+		// the grace period may be over, but the remaining spans are still worth exporting
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), tracingFlushTimeout)
+		defer flushCancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			logger.Error().Err(err).Msg("Error flushing traces")
+		}
+	}()
+
+	// the web configuration file is read on each new connection
+	// so that renewed certificates are used without a restart
+	webConfig := &web.FlagConfig{
+		WebListenAddresses: &[]string{*listenAddress},
+		WebConfigFile:      webConfigFile,
+	}
+
+	if err := web.ListenAndServe(server, webConfig, createSlogLogger(*logLevel)); err != http.ErrServerClosed {
 		logger.Error().Err(err).Msg("Error starting HTTP server")
 		os.Exit(1)
 	}
+
+	<-stopped
+	logger.Info().Msg("Shutdown completed")
+}
+
+// reply with the JSON encoded error
+func jsonError(w http.ResponseWriter, code int, err error) {
+	var e *validation.Error
+	if !errors.As(err, &e) {
+		e = &validation.Error{Message: err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(e)
 }
 
 // get the min of Prometheus scrape timeout (if found) and the flag timeout
@@ -186,8 +609,9 @@ func getTimeout(r *http.Request, timeout time.Duration) time.Duration {
 	return timeout
 }
 
-// create logger with the provided log level
-func createLogger(l string) (logger log.Logger, err error) {
+// create logger with the provided log level writing to w, and return the filtered
+// output as well so that the probes logs can be written to it
+func createLogger(l string, w io.Writer) (logger log.Logger, output io.Writer, err error) {
 	var lvl log.Level
 	switch l {
 	case "error":
@@ -199,18 +623,49 @@ func createLogger(l string) (logger log.Logger, err error) {
 	case "debug":
 		lvl = log.DebugLevel
 	default:
-		return log.Nop(), fmt.Errorf("unrecognized log level: %v", l)
+		return log.Nop(), io.Discard, fmt.Errorf("unrecognized log level: %v", l)
 	}
 
 	log.MessageFieldName = "msg"
 	log.TimestampFieldName = "timestamp"
 	log.TimeFieldFormat = time.RFC3339Nano
 
-	log.SetGlobalLevel(lvl)
+	// the level is applied to the output rather than the loggers,
+	// so that the probes debug logs can still be captured
+	output = &log.FilteredLevelWriter{Writer: log.LevelWriterAdapter{Writer: w}, Level: lvl}
 
-	logger = log.New(os.Stdout).With().Timestamp().Logger()
+	logger = log.New(output).With().Timestamp().Logger()
 
-	return logger, nil
+	return logger, output, nil
+}
+
+// create a log/slog logger, as required by the exporter toolkit,
+// with the same format and level as the zerolog one
+func createSlogLogger(l string) *slog.Logger {
+	lvl := slog.LevelDebug
+	switch l {
+	case "error":
+		lvl = slog.LevelError
+	case "warn":
+		lvl = slog.LevelWarn
+	case "info":
+		lvl = slog.LevelInfo
+	}
+
+	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level: lvl,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			switch a.Key {
+			case slog.TimeKey:
+				a.Key = log.TimestampFieldName
+			case slog.LevelKey:
+				a.Value = slog.StringValue(strings.ToLower(a.Value.String()))
+			case slog.MessageKey:
+				a.Key = log.MessageFieldName
+			}
+			return a
+		},
+	}))
 }
 
 // validate the provided probe timeout
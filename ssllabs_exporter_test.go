@@ -15,37 +15,163 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"log/slog"
+	"maps"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
+	ssllabsApi "github.com/essentialkaos/sslscan/v13"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	log "github.com/rs/zerolog"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/config"
+	"github.com/anas-aso/ssllabs_exporter/internal/exporter"
+	"github.com/anas-aso/ssllabs_exporter/internal/validation"
 )
 
+// create an assessments tracker with a fake assessment, waited for at the end of the test
+func newTestAssessments(t *testing.T, resultsCache cache, timeout time.Duration) *assessments {
+	t.Helper()
+
+	running := newAssessments(context.Background(), log.Nop(), timeout, resultsCache, false)
+	running.handle = func(ctx context.Context, logger log.Logger, target string) *exporter.Result {
+		return gradedResult(target, "A", time.Now())
+	}
+
+	// the in-progress assessments would otherwise outlive the test
+	t.Cleanup(func() { running.shutdown(context.Background()) })
+
+	return running
+}
+
 func TestProbeHandler(t *testing.T) {
-	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		time.Sleep(10 * time.Second)
-	}))
-	defer testServer.Close()
+	var cases = []struct {
+		name           string
+		target         string
+		probeConfig    config.ProbeConfig
+		bearerToken    string
+		expectedStatus int
+	}{
+		{
+			name:           "valid_target",
+			target:         "prometheus.io",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "missing_target",
+			target:         "",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "ip_address_target",
+			target:         "http://127.0.0.1:443",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "denied_target",
+			target: "prometheus.io",
+			probeConfig: config.ProbeConfig{
+				Deny: config.TargetMatcher{Suffixes: []string{"io"}},
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:   "not_allowed_target",
+			target: "prometheus.io",
+			probeConfig: config.ProbeConfig{
+				Allow: config.TargetMatcher{Globs: []string{"*.example.com"}},
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "missing_credentials",
+			target:         "prometheus.io",
+			probeConfig:    config.ProbeConfig{AuthConfig: config.AuthConfig{BearerToken: "secret"}},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "wrong_credentials",
+			target:         "prometheus.io",
+			probeConfig:    config.ProbeConfig{AuthConfig: config.AuthConfig{BearerToken: "secret"}},
+			bearerToken:    "guess",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "valid_credentials",
+			target:         "prometheus.io",
+			probeConfig:    config.ProbeConfig{AuthConfig: config.AuthConfig{BearerToken: "secret"}},
+			bearerToken:    "secret",
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, c := range cases {
+		req, err := http.NewRequest("GET", "?target="+url.QueryEscape(c.target), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "1")
+		if c.bearerToken != "" {
+			req.Header.Set("Authorization", "Bearer "+c.bearerToken)
+		}
 
-	req, err := http.NewRequest("GET", "?target="+testServer.URL, nil)
-	if err != nil {
-		t.Fatal(err)
+		testRecorder := httptest.NewRecorder()
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			resultsCache := newMemoryCache(1, newRetentionPolicy(1), 0, 0, 0)
+			probeHandler(w, r, 1, resultsCache, newTestAssessments(t, resultsCache, 1), &c.probeConfig, newProbeLogs(0))
+		})
+
+		handler.ServeHTTP(testRecorder, req)
+
+		if status := testRecorder.Code; status != c.expectedStatus {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedStatus, status)
+		}
 	}
+}
 
-	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "1")
+func TestProbeMetrics(t *testing.T) {
+	resultsCache := newMemoryCache(time.Minute, newRetentionPolicy(time.Minute), 0, 0, 0)
+	defer resultsCache.stop()
+	resultsCache.add("prometheus.io", exporter.NewResult("prometheus.io", time.Now(), time.Second, &ssllabsApi.AnalyzeInfo{}, nil))
 
-	testRecorder := httptest.NewRecorder()
-	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		probeHandler(w, r, log.Nop(), 1, newCache(1, 1))
-	})
+	served := probesServed.WithLabelValues("success", cacheHit)
+	before := testutil.ToFloat64(served)
 
-	handler.ServeHTTP(testRecorder, req)
+	req := httptest.NewRequest("GET", "/probe?target=prometheus.io", nil)
+	probeHandler(httptest.NewRecorder(), req, time.Second, resultsCache, newTestAssessments(t, resultsCache, time.Second), &config.ProbeConfig{}, newProbeLogs(0))
 
-	if status := testRecorder.Code; status != http.StatusOK {
-		t.Errorf("probe handler returned the wrong status code.\nExpected : %v\nGot : %v\n", status, http.StatusOK)
+	if got := testutil.ToFloat64(served); got != before+1 {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "cached_probe", before+1, got)
+	}
+}
+
+func TestProbeDebug(t *testing.T) {
+	resultsCache := newMemoryCache(time.Minute, newRetentionPolicy(time.Minute), 0, 0, 0)
+	defer resultsCache.stop()
+	resultsCache.add("prometheus.io", exporter.NewResult("prometheus.io", time.Now(), time.Second, &ssllabsApi.AnalyzeInfo{}, nil))
+
+	history := newProbeLogs(10)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/probe?target=prometheus.io&debug=true", nil)
+	probeHandler(w, req, time.Second, resultsCache, newTestAssessments(t, resultsCache, time.Second), &config.ProbeConfig{}, history)
+
+	body := w.Body.String()
+	for _, expected := range []string{"Logs for the probe:", "serving results from cache", "Metrics that would have been returned:", "ssllabs_probe_success 1"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "debug_response", expected, body)
+		}
+	}
+
+	if logs := history.list(); len(logs) != 1 || !strings.Contains(logs[0].logs, "serving results from cache") {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "logs_history", "serving results from cache", logs)
 	}
 }
 
@@ -93,19 +219,38 @@ func TestGetTimeout(t *testing.T) {
 }
 
 func TestCreateLogger(t *testing.T) {
-	_, err := createLogger("unexpected")
+	_, _, err := createLogger("unexpected", os.Stdout)
 	if err == nil {
 		t.Errorf("logger created with unexpected level")
 	}
 
 	for _, lvl := range []string{"error", "warn", "info", "debug"} {
-		_, err := createLogger(lvl)
+		_, _, err := createLogger(lvl, os.Stdout)
 		if err != nil {
 			t.Errorf("failed to create logger with level : %v", lvl)
 		}
 	}
 }
 
+func TestCreateSlogLogger(t *testing.T) {
+	var cases = []struct {
+		level           string
+		expectedEnabled slog.Level
+		expectedMuted   slog.Level
+	}{
+		{level: "error", expectedEnabled: slog.LevelError, expectedMuted: slog.LevelWarn},
+		{level: "warn", expectedEnabled: slog.LevelWarn, expectedMuted: slog.LevelInfo},
+		{level: "info", expectedEnabled: slog.LevelInfo, expectedMuted: slog.LevelDebug},
+	}
+
+	for _, c := range cases {
+		logger := createSlogLogger(c.level)
+		if !logger.Enabled(context.Background(), c.expectedEnabled) || logger.Enabled(context.Background(), c.expectedMuted) {
+			t.Errorf("Test case : %v failed, logger created with the wrong level", c.level)
+		}
+	}
+}
+
 func TestValidateTimeout(t *testing.T) {
 	var cases = []struct {
 		name            string
@@ -140,3 +285,100 @@ func TestValidateTimeout(t *testing.T) {
 		}
 	}
 }
+
+func TestProbeLabels(t *testing.T) {
+	var cases = []struct {
+		name           string
+		query          string
+		discovered     map[string]string
+		expectedResult map[string]string
+		expectedReason string
+	}{
+		{
+			name:           "no_labels",
+			query:          "target=example.com",
+			expectedResult: nil,
+		},
+		{
+			name:           "parameters",
+			query:          "target=example.com&label_team=web&label_env=prod",
+			expectedResult: map[string]string{"team": "web", "env": "prod"},
+		},
+		{
+			name:           "merged_with_discovered",
+			query:          "label_env=prod&label_team=web",
+			discovered:     map[string]string{"team": "web"},
+			expectedResult: map[string]string{"team": "web", "env": "prod"},
+		},
+		{
+			name:           "discovered_only",
+			query:          "target=example.com",
+			discovered:     map[string]string{"team": "web"},
+			expectedResult: map[string]string{"team": "web"},
+		},
+		{
+			name:           "conflict",
+			query:          "label_team=api",
+			discovered:     map[string]string{"team": "web"},
+			expectedReason: validation.ReasonLabelConflict,
+		},
+		{
+			name:           "invalid_name",
+			query:          "label_team-name=web",
+			expectedReason: validation.ReasonInvalidLabel,
+		},
+		{
+			name:           "metric_label",
+			query:          "label_grade=A",
+			expectedReason: validation.ReasonLabelConflict,
+		},
+	}
+
+	for _, c := range cases {
+		query, err := url.ParseQuery(c.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := probeLabels(query, c.discovered)
+		if reason := rejectionReason(err); err != nil && reason != c.expectedReason {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedReason, reason)
+		}
+		if err == nil && (c.expectedReason != "" || !maps.Equal(result, c.expectedResult)) {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedResult, result)
+		}
+	}
+}
+
+func TestProbeWithLabels(t *testing.T) {
+	resultsCache := newMemoryCache(time.Minute, newRetentionPolicy(time.Minute), 0, 0, 0)
+	defer resultsCache.stop()
+	resultsCache.add("prometheus.io", exporter.NewResult("prometheus.io", time.Now(), time.Second, &ssllabsApi.AnalyzeInfo{}, nil))
+	running := newTestAssessments(t, resultsCache, time.Second)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/probe?target=prometheus.io&label_team=web", nil)
+	probeHandler(w, req, time.Second, resultsCache, running, &config.ProbeConfig{}, newProbeLogs(0))
+
+	if body := w.Body.String(); !strings.Contains(body, `ssllabs_probe_success{team="web"} 1`) {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "labeled_metrics", `team="web"`, body)
+	}
+
+	// the cached result is shared with the probes passing other labels
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/probe?target=prometheus.io", nil)
+	probeHandler(w, req, time.Second, resultsCache, running, &config.ProbeConfig{}, newProbeLogs(0))
+
+	if body := w.Body.String(); !strings.Contains(body, "ssllabs_probe_success 1") {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "unlabeled_metrics", "ssllabs_probe_success 1", body)
+	}
+
+	running.labels = func(string) map[string]string { return map[string]string{"team": "web"} }
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/probe?target=prometheus.io&label_team=api", nil)
+	probeHandler(w, req, time.Second, resultsCache, running, &config.ProbeConfig{}, newProbeLogs(0))
+
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), validation.ReasonLabelConflict) {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "conflicting_labels", http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/build"
+	"github.com/anas-aso/ssllabs_exporter/internal/config"
+	"github.com/anas-aso/ssllabs_exporter/internal/exporter"
+	"github.com/anas-aso/ssllabs_exporter/internal/ssllabs"
+)
+
+// name of the exporter tracer
+const tracerName = "github.com/anas-aso/ssllabs_exporter"
+
+// return the exporter tracer from the current tracer provider
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// setup the export of the traces to the configured OTLP endpoint and
+// return a function flushing the remaining spans on shutdown.
+// The trace context of the incoming requests is propagated even if disabled.
+func setupTracing(cfg *config.TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if !cfg.Enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(cfg.Endpoint),
+		otlptracehttp.WithHeaders(cfg.Headers),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ratio := 1.0
+	if cfg.SamplingRatio != nil {
+		ratio = *cfg.SamplingRatio
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "ssllabs_exporter"),
+			attribute.String("service.version", build.Version),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// set the status and grade attributes of a span tracing an assessment
+func setResultAttributes(span trace.Span, result *exporter.Result) {
+	if result.Err != nil {
+		span.SetAttributes(attribute.String("status", ssllabs.ErrorStatus(result.Err)))
+		span.SetStatus(codes.Error, result.Err.Error())
+		return
+	}
+
+	span.SetAttributes(attribute.String("status", ssllabs.StatusReady), attribute.String("grade", result.Grade()))
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	ssllabsApi "github.com/essentialkaos/sslscan/v13"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/config"
+	"github.com/anas-aso/ssllabs_exporter/internal/exporter"
+)
+
+func TestSetupTracing(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+
+	var cases = []struct {
+		name   string
+		config config.TracingConfig
+	}{
+		{name: "disabled"},
+		{name: "enabled", config: config.TracingConfig{Endpoint: "http://127.0.0.1:4318/v1/traces"}},
+	}
+
+	for _, c := range cases {
+		shutdown, err := setupTracing(&c.config)
+		if err != nil {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, nil, err)
+			continue
+		}
+
+		if err := shutdown(context.Background()); err != nil {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, nil, err)
+		}
+	}
+}
+
+func TestProbeTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	if _, err := setupTracing(&config.TracingConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	resultsCache := newMemoryCache(time.Minute, newRetentionPolicy(time.Minute), 0, 0, 0)
+	defer resultsCache.stop()
+	resultsCache.add("prometheus.io", exporter.NewResult("prometheus.io", time.Now(), time.Second, &ssllabsApi.AnalyzeInfo{
+		Endpoints: []*ssllabsApi.EndpointInfo{{Grade: "A+"}},
+	}, nil))
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("GET", "/probe?target=prometheus.io", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	probeHandler(httptest.NewRecorder(), req, time.Second, resultsCache, newTestAssessments(t, resultsCache, time.Second), &config.ProbeConfig{}, newProbeLogs(0))
+
+	expectedAttributes := map[string]map[attribute.Key]string{
+		"cache.get": {"target": "prometheus.io"},
+		"probe":     {"target": "prometheus.io", "status": "READY", "grade": "A+", "cache": cacheHit},
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != len(expectedAttributes) {
+		t.Fatalf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "spans_count", len(expectedAttributes), len(spans))
+	}
+
+	for _, span := range spans {
+		if span.SpanContext().TraceID().String() != traceID {
+			t.Errorf("Test case : %v failed, trace context not propagated.\nExpected : %v\nGot : %v\n", span.Name(), traceID, span.SpanContext().TraceID())
+		}
+
+		attributes := map[attribute.Key]string{}
+		for _, kv := range span.Attributes() {
+			attributes[kv.Key] = kv.Value.Emit()
+		}
+
+		for key, expected := range expectedAttributes[span.Name()] {
+			if attributes[key] != expected {
+				t.Errorf("Test case : %v failed.\nExpected : %v=%v\nGot : %v\n", span.Name(), key, expected, attributes)
+			}
+		}
+	}
+}
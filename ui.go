@@ -0,0 +1,116 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	_ "embed"
+	"html/template"
+	"net/http"
+	"time"
+
+	log "github.com/rs/zerolog"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/config"
+	"github.com/anas-aso/ssllabs_exporter/internal/validation"
+)
+
+//go:embed ui/index.html
+var indexHTML string
+
+var indexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"ago": func(t interface{}) time.Duration {
+		return time.Since(toTime(t)).Round(time.Second)
+	},
+	"until": func(t time.Time) time.Duration {
+		return time.Until(t).Round(time.Second)
+	},
+}).Parse(indexHTML))
+
+// in-progress assessment listed in the UI
+type runningAssessment struct {
+	Target string
+	Start  time.Time
+}
+
+// dereference optional time values used in the template
+func toTime(t interface{}) time.Time {
+	switch v := t.(type) {
+	case time.Time:
+		return v
+	case *time.Time:
+		if v != nil {
+			return *v
+		}
+	}
+
+	return time.Time{}
+}
+
+// render the UI listing the cached and in-progress assessments.
+// The cache actions are only shown if the admin endpoints are enabled.
+func indexHandler(w http.ResponseWriter, r *http.Request, logger log.Logger, resultsCache cache, running *assessments, adminEnabled bool) {
+	data := struct {
+		Results      []resultResponse
+		Running      []runningAssessment
+		AdminEnabled bool
+	}{AdminEnabled: adminEnabled}
+
+	for _, c := range resultsCache.list() {
+		data.Results = append(data.Results, newResultResponse(c))
+	}
+
+	for _, as := range running.list() {
+		data.Running = append(data.Running, runningAssessment{Target: as.target, Start: as.start})
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := indexTemplate.Execute(w, data); err != nil {
+		logger.Error().Err(err).Msg("failed to render the UI")
+	}
+}
+
+// trigger a new assessment of a target from the UI
+func refreshFormHandler(w http.ResponseWriter, r *http.Request, logger log.Logger, running *assessments, probeConfig *config.ProbeConfig) {
+	target, err := validation.Target(r.PostFormValue("target"))
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := allowed(target, probeConfig); err != nil {
+		logger.Error().Err(err).Msg("Target not allowed")
+		jsonError(w, http.StatusForbidden, err)
+		return
+	}
+
+	logger.Info().Str("target", target).Msg("re-assessment requested from the UI")
+	running.refresh(r.Context(), target)
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// remove a target from the cache from the UI
+func evictFormHandler(w http.ResponseWriter, r *http.Request, logger log.Logger, resultsCache cache) {
+	target, err := validation.Target(r.PostFormValue("target"))
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	logger.Info().Str("target", target).Msg("cache eviction requested from the UI")
+	resultsCache.remove(target)
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/rs/zerolog"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/config"
+	"github.com/anas-aso/ssllabs_exporter/internal/exporter"
+)
+
+func TestIndexHandler(t *testing.T) {
+	resultsCache := newMemoryCache(time.Minute, newRetentionPolicy(time.Minute), 0, 0, 0)
+	resultsCache.add("prometheus.io", exporter.Interrupted("prometheus.io", time.Now(), context.DeadlineExceeded))
+
+	running := newAssessments(context.Background(), log.Nop(), time.Minute, resultsCache, false)
+	release := make(chan struct{})
+	defer close(release)
+	running.handle = func(ctx context.Context, logger log.Logger, target string) *exporter.Result {
+		<-release
+		return &exporter.Result{Target: target, Registry: prometheus.NewRegistry()}
+	}
+	running.start(context.Background(), "grafana.com")
+
+	testRecorder := httptest.NewRecorder()
+	indexHandler(testRecorder, httptest.NewRequest("GET", "/", nil), log.Nop(), resultsCache, running, true)
+
+	body := testRecorder.Body.String()
+	for _, expected := range []string{"prometheus.io", "failed (timeout)", "grafana.com", "Evict"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("UI doesn't contain %q", expected)
+		}
+	}
+}
+
+func TestEvictFormHandler(t *testing.T) {
+	resultsCache := newMemoryCache(time.Minute, newRetentionPolicy(time.Minute), 0, 0, 0)
+	resultsCache.add("prometheus.io", exporter.Interrupted("prometheus.io", time.Now(), context.DeadlineExceeded))
+
+	req := httptest.NewRequest("POST", "/ui/evict", strings.NewReader(url.Values{"target": {"Prometheus.io"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	testRecorder := httptest.NewRecorder()
+	evictFormHandler(testRecorder, req, log.Nop(), resultsCache)
+
+	if testRecorder.Code != http.StatusSeeOther {
+		t.Errorf("Unexpected status code.\nExpected : %v\nGot : %v\n", http.StatusSeeOther, testRecorder.Code)
+	}
+
+	if resultsCache.get("prometheus.io") != nil || resultsCache.lru.Len() != 0 {
+		t.Errorf("Target was not evicted from the cache")
+	}
+}
+
+func TestRefreshFormHandler(t *testing.T) {
+	resultsCache := newMemoryCache(time.Minute, newRetentionPolicy(time.Minute), 0, 0, 0)
+	running := newAssessments(context.Background(), log.Nop(), time.Minute, resultsCache, false)
+	running.handle = func(ctx context.Context, logger log.Logger, target string) *exporter.Result {
+		return &exporter.Result{Target: target, Registry: prometheus.NewRegistry()}
+	}
+	probeConfig := &config.ProbeConfig{Deny: config.TargetMatcher{Suffixes: []string{"internal.example.com"}}}
+
+	var cases = []struct {
+		target         string
+		expectedStatus int
+	}{
+		{target: "prometheus.io", expectedStatus: http.StatusSeeOther},
+		{target: "db.internal.example.com", expectedStatus: http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest("POST", "/ui/refresh", strings.NewReader(url.Values{"target": {c.target}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		testRecorder := httptest.NewRecorder()
+		refreshFormHandler(testRecorder, req, log.Nop(), running, probeConfig)
+
+		if testRecorder.Code != c.expectedStatus {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.target, c.expectedStatus, testRecorder.Code)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/rs/zerolog"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/build"
+	"github.com/anas-aso/ssllabs_exporter/internal/config"
+	"github.com/anas-aso/ssllabs_exporter/internal/exporter"
+)
+
+// webhooks delivery defaults
+const (
+	defaultWebhookTimeout    = 10 * time.Second
+	defaultWebhookMaxRetries = 3
+)
+
+// headers of the webhooks requests
+const (
+	webhookEventHeader     = "X-SSLLabs-Exporter-Event"
+	webhookSignatureHeader = "X-SSLLabs-Exporter-Signature"
+)
+
+// delay before the first retry of a failed delivery, doubled on each retry
+var webhookRetryDelay = time.Second
+
+var webhookDeliveries = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "ssllabs_exporter_webhook_deliveries_total",
+		Help: "Number of webhook notifications by webhook, event and result",
+	},
+	[]string{"webhook", "event", "result"},
+)
+
+// webhookPayload is the JSON body POSTed to the webhooks
+type webhookPayload struct {
+	Event          string            `json:"event"`
+	Target         string            `json:"target"`
+	AssessmentTime time.Time         `json:"assessment_time"`
+	Grade          string            `json:"grade"`
+	PreviousGrade  string            `json:"previous_grade"`
+	MinGrade       string            `json:"min_grade,omitempty"`
+	Changes        []exporter.Change `json:"changes"`
+}
+
+// webhooks notifies the configured HTTP endpoints about the assessments changes
+type webhooks struct {
+	configs []config.WebhookConfig
+	client  *http.Client
+	logger  log.Logger
+
+	// parent context of the deliveries, canceled to abort them
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// used to wait for the in-progress deliveries on shutdown
+	wg sync.WaitGroup
+}
+
+// notify the webhooks about the changes between two consecutive assessments of the target.
+// The deliveries run in the background.
+func (wh *webhooks) notify(target string, previous, current exporter.Snapshot, changes []exporter.Change) {
+	if wh == nil {
+		return
+	}
+
+	for i := range wh.configs {
+		cfg := &wh.configs[i]
+		for _, payload := range webhookPayloads(cfg, target, previous, current, changes) {
+			wh.wg.Add(1)
+			go func() {
+				defer wh.wg.Done()
+				wh.deliver(cfg, payload)
+			}()
+		}
+	}
+}
+
+// build the payloads of the events the webhook is notified about
+func webhookPayloads(cfg *config.WebhookConfig, target string, previous, current exporter.Snapshot, changes []exporter.Change) []webhookPayload {
+	var payloads []webhookPayload
+
+	newPayload := func(event string, changes []exporter.Change) webhookPayload {
+		return webhookPayload{
+			Event:          event,
+			Target:         target,
+			AssessmentTime: current.AssessmentTime,
+			Grade:          current.Grade,
+			PreviousGrade:  previous.Grade,
+			Changes:        changes,
+		}
+	}
+
+	if cfg.Notified(target, config.EventGradeBelow) &&
+		exporter.GradeBelow(current.Grade, cfg.MinGrade) && !exporter.GradeBelow(previous.Grade, cfg.MinGrade) {
+		payload := newPayload(config.EventGradeBelow, filterChanges(changes, exporter.ChangeGrade))
+		payload.MinGrade = cfg.MinGrade
+		payloads = append(payloads, payload)
+	}
+
+	// fixed vulnerabilities are not notified
+	var vulnerabilities []exporter.Change
+	for _, c := range filterChanges(changes, exporter.ChangeVulnerability) {
+		if c.Current != "" {
+			vulnerabilities = append(vulnerabilities, c)
+		}
+	}
+	if len(vulnerabilities) > 0 && cfg.Notified(target, config.EventVulnerability) {
+		payloads = append(payloads, newPayload(config.EventVulnerability, vulnerabilities))
+	}
+
+	if cfg.Notified(target, config.EventCertificate) && !slices.Equal(previous.LeafSerials, current.LeafSerials) {
+		payloads = append(payloads, newPayload(config.EventCertificate, filterChanges(changes, exporter.ChangeCertificate)))
+	}
+
+	return payloads
+}
+
+// changes of the category
+func filterChanges(changes []exporter.Change, category string) []exporter.Change {
+	filtered := []exporter.Change{}
+	for _, c := range changes {
+		if c.Category == category {
+			filtered = append(filtered, c)
+		}
+	}
+
+	return filtered
+}
+
+// POST the payload to the webhook, retrying failed attempts with an exponential backoff
+func (wh *webhooks) deliver(cfg *config.WebhookConfig, payload webhookPayload) {
+	logger := wh.logger.With().Str("webhook", cfg.Name).Str("event", payload.Event).Str("target", payload.Target).Logger()
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to encode the webhook payload")
+		webhookDeliveries.WithLabelValues(cfg.Name, payload.Event, "failure").Inc()
+		return
+	}
+
+	maxRetries := defaultWebhookMaxRetries
+	if cfg.MaxRetries != nil {
+		maxRetries = *cfg.MaxRetries
+	}
+
+	delay := webhookRetryDelay
+	for attempt := 0; ; attempt++ {
+		err = wh.post(cfg, payload.Event, body)
+		if err == nil {
+			logger.Debug().Int("attempt", attempt).Msg("webhook notified")
+			webhookDeliveries.WithLabelValues(cfg.Name, payload.Event, "success").Inc()
+			return
+		}
+
+		if attempt >= maxRetries {
+			break
+		}
+
+		logger.Warn().Err(err).Int("attempt", attempt).Dur("retry_in", delay).Msg("failed to notify the webhook")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-wh.ctx.Done():
+			timer.Stop()
+			err = wh.ctx.Err()
+		case <-timer.C:
+		}
+		if wh.ctx.Err() != nil {
+			break
+		}
+
+		delay *= 2
+	}
+
+	logger.Error().Err(err).Msg("failed to deliver the webhook notification")
+	webhookDeliveries.WithLabelValues(cfg.Name, payload.Event, "failure").Inc()
+}
+
+// send a single delivery attempt, signing the body if a secret is set
+func (wh *webhooks) post(cfg *config.WebhookConfig, event string, body []byte) error {
+	timeout := defaultWebhookTimeout
+	if cfg.Timeout != nil {
+		timeout = *cfg.Timeout
+	}
+
+	ctx, cancel := context.WithTimeout(wh.ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ssllabs-exporter/"+build.Version)
+	req.Header.Set(webhookEventHeader, event)
+	if cfg.Secret != "" {
+		req.Header.Set(webhookSignatureHeader, "sha256="+signPayload(cfg.Secret, body))
+	}
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// hex encoded HMAC-SHA256 of the body
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// shutdown waits for the in-progress deliveries until the context is done,
+// then aborts the remaining ones
+func (wh *webhooks) shutdown(ctx context.Context) {
+	if wh == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wh.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+
+	wh.logger.Warn().Msg("aborting in-progress webhook deliveries")
+	wh.cancel()
+	<-done
+}
+
+// create the notifier of the configured webhooks, nil if there is none
+func newWebhooks(logger log.Logger, configs []config.WebhookConfig) *webhooks {
+	if len(configs) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &webhooks{
+		configs: configs,
+		client:  &http.Client{},
+		logger:  logger,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
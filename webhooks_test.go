@@ -0,0 +1,198 @@
+// Copyright 2020 Anas Ait Said Oubrahim
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/rs/zerolog"
+
+	"github.com/anas-aso/ssllabs_exporter/internal/config"
+	"github.com/anas-aso/ssllabs_exporter/internal/exporter"
+)
+
+// webhook receiver failing the first requests
+type webhookReceiver struct {
+	mu       sync.Mutex
+	failures int
+	requests int
+	payloads []webhookPayload
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	wr.requests++
+	if wr.requests <= wr.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	var payload webhookPayload
+	json.Unmarshal(body, &payload)
+
+	wr.payloads = append(wr.payloads, payload)
+	wr.bodies = append(wr.bodies, body)
+	wr.headers = append(wr.headers, r.Header)
+}
+
+func TestWebhooksDelivery(t *testing.T) {
+	webhookRetryDelay = time.Millisecond
+
+	receiver := &webhookReceiver{failures: 2}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	cfg := []config.WebhookConfig{{Name: "test", URL: server.URL, Secret: "secret", MinGrade: "A"}}
+	wh := newWebhooks(log.Nop(), cfg)
+
+	resultsCache := newMemoryCache(time.Minute, newRetentionPolicy(time.Minute), 0, 0, 3)
+	defer resultsCache.stop()
+	running := newAssessments(context.Background(), log.Nop(), time.Minute, resultsCache, false)
+	running.webhooks = wh
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	running.recordHistory(log.Nop(), "example.com", gradedResult("example.com", "A", start))
+
+	// the grade drops below the minimum grade and a new vulnerability is found
+	regression := gradedResult("example.com", "B", start.Add(time.Minute))
+	regression.Info.Endpoints[0].Details.Heartbleed = true
+	running.recordHistory(log.Nop(), "example.com", regression)
+
+	wh.shutdown(context.Background())
+
+	events := make(map[string]webhookPayload)
+	for i, payload := range receiver.payloads {
+		events[payload.Event] = payload
+
+		headers := receiver.headers[i]
+		signature := "sha256=" + signPayload("secret", receiver.bodies[i])
+		if headers.Get(webhookSignatureHeader) != signature || headers.Get(webhookEventHeader) != payload.Event {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "signed_"+payload.Event, signature, headers.Get(webhookSignatureHeader))
+		}
+	}
+
+	if len(receiver.payloads) != 2 || receiver.requests != 4 {
+		t.Fatalf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "deliveries", 2, receiver.payloads)
+	}
+
+	if grade := events[config.EventGradeBelow]; grade.Grade != "B" || grade.PreviousGrade != "A" || grade.MinGrade != "A" {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "grade_below", "A -> B", grade)
+	}
+
+	if vulnerability := events[config.EventVulnerability]; len(vulnerability.Changes) != 1 || vulnerability.Changes[0].Current != "heartbleed" {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "vulnerability", "heartbleed", vulnerability)
+	}
+}
+
+func TestWebhooksRetriesExhausted(t *testing.T) {
+	webhookRetryDelay = time.Millisecond
+
+	receiver := &webhookReceiver{failures: 10}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	retries := 1
+	cfg := []config.WebhookConfig{{Name: "failing", URL: server.URL, MaxRetries: &retries}}
+	wh := newWebhooks(log.Nop(), cfg)
+
+	before := testutil.ToFloat64(webhookDeliveries.WithLabelValues("failing", config.EventCertificate, "failure"))
+
+	previous := exporter.Snapshot{Grade: "A", LeafSerials: []string{"01"}}
+	current := exporter.Snapshot{Grade: "A", LeafSerials: []string{"02"}}
+	wh.notify("example.com", previous, current, exporter.Diff(previous, current))
+	wh.shutdown(context.Background())
+
+	if receiver.requests != 2 {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "attempts", 2, receiver.requests)
+	}
+
+	if got := testutil.ToFloat64(webhookDeliveries.WithLabelValues("failing", config.EventCertificate, "failure")); got != before+1 {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "failed_delivery", before+1, got)
+	}
+}
+
+func TestWebhookPayloads(t *testing.T) {
+	previous := exporter.Snapshot{Grade: "A", LeafSerials: []string{"01"}, Vulnerabilities: []string{"poodle"}}
+
+	var cases = []struct {
+		name           string
+		cfg            config.WebhookConfig
+		current        exporter.Snapshot
+		expectedEvents []string
+	}{
+		{
+			name:           "unchanged",
+			cfg:            config.WebhookConfig{MinGrade: "A"},
+			current:        previous,
+			expectedEvents: nil,
+		},
+		{
+			name:           "fixed_vulnerability",
+			cfg:            config.WebhookConfig{},
+			current:        exporter.Snapshot{Grade: "A", LeafSerials: []string{"01"}},
+			expectedEvents: nil,
+		},
+		{
+			name:           "grade_above_minimum",
+			cfg:            config.WebhookConfig{MinGrade: "B"},
+			current:        exporter.Snapshot{Grade: "B", LeafSerials: []string{"01"}, Vulnerabilities: []string{"poodle"}},
+			expectedEvents: nil,
+		},
+		{
+			name:           "certificate_renewed",
+			cfg:            config.WebhookConfig{MinGrade: "A"},
+			current:        exporter.Snapshot{Grade: "A", LeafSerials: []string{"02"}, Vulnerabilities: []string{"poodle"}},
+			expectedEvents: []string{config.EventCertificate},
+		},
+		{
+			name:           "filtered_target",
+			cfg:            config.WebhookConfig{Targets: config.TargetMatcher{Suffixes: []string{"example.org"}}},
+			current:        exporter.Snapshot{Grade: "A", LeafSerials: []string{"02"}},
+			expectedEvents: nil,
+		},
+	}
+
+	for _, c := range cases {
+		var events []string
+		for _, payload := range webhookPayloads(&c.cfg, "example.com", previous, c.current, exporter.Diff(previous, c.current)) {
+			events = append(events, payload.Event)
+		}
+
+		if len(events) != len(c.expectedEvents) || len(events) > 0 && events[0] != c.expectedEvents[0] {
+			t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", c.name, c.expectedEvents, events)
+		}
+	}
+}
+
+func TestSignPayload(t *testing.T) {
+	// echo -n '{}' | openssl dgst -sha256 -hmac secret
+	expected := "77325902caca812dc259733aacd046b73817372c777b8d95b402647474516e13"
+	if got := signPayload("secret", []byte("{}")); got != expected {
+		t.Errorf("Test case : %v failed.\nExpected : %v\nGot : %v\n", "signature", expected, got)
+	}
+}